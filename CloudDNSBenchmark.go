@@ -18,6 +18,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"math"
@@ -34,22 +35,183 @@ import (
 var (
 	numOfQueries  = flag.Int("q", 20, "Number of domains to test max is 200")
 	numOResolvers = flag.Int("r", 40, "Number of simutanious resolvers")
+	perServer     = flag.Int("c", 0, "Maximum simultaneous queries per server, 0 to share -r evenly between servers")
 	typeofHost    = flag.String("type", "t", "use top domains or others")
+	alpha         = flag.Float64("alpha", 0.05, "Significance level used to split servers into tiers")
+	order         = flag.String("order", "random", "Query dispatch order: random, roundrobin or host")
+	adaptive      = flag.Bool("adaptive", false, "Keep querying in rounds until the top servers are ranked with confidence")
+	protocol      = flag.String("protocol", "udp", "Protocol queries are sent over: udp, tcp or tcp-tls")
 )
 
 func main() {
 
-	flag.Parse()
+	// "serve" runs the HTTP API instead of a single benchmark, "agent"
+	// runs one and streams its results to a "coordinator"
+	args := os.Args[1:]
+	var mode string
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve", "agent", "coordinator":
+			mode, args = args[0], args[1:]
+		}
+	}
+	flag.CommandLine.Parse(args)
+	if mode == "coordinator" {
+		if err := coordinate(); err != nil {
+			fmt.Println("coordinator:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := setupResolver(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var testDomains []testDomain
+	if *filterList != "" {
+		var err error
+		if testDomains, err = loadFilterList(*filterList); err != nil {
+			fmt.Println("filter:", err)
+			os.Exit(1)
+		}
+	}
+
+	if *ecs != "" {
+		if _, err := parseECS(*ecs); err != nil {
+			fmt.Println("ecs:", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := checkSources(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := loadGeo(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := checkProtocol(*protocol); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := checkOrder(*order); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := checkFamily(*family); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := checkRetries(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := setupUI(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var agent *streamer
+	if mode == "agent" {
+		var err error
+		if agent, err = newStreamer(progress); err != nil {
+			fmt.Println("agent:", err)
+			os.Exit(1)
+		}
+		progress = agent
+	}
+
+	if *format != "text" && *format != "html" {
+		fmt.Printf("format %q: want text or html\n", *format)
+		os.Exit(1)
+	}
 
 	fmt.Println("\nCloudDNSBenchmark version 0.0.3, Copyright (C) 2016 Josh Gardiner")
 	fmt.Println("CloudDNSBenchmark comes with ABSOLUTELY NO WARRANTY;")
 	fmt.Println("This is free software, and you are welcome to redistribute it")
 	fmt.Println("under certain conditions;")
-	fmt.Printf("\n\nStarting CloudDNS Benchmarks, using %d random domains\n", *numOfQueries)
+	if system := systemServers(); len(system) > 0 {
+		fmt.Printf("\nSystem nameservers: %v\n", system)
+	}
 
-	results := generator()
+	if mode == "serve" {
+		if err := serve(); err != nil {
+			fmt.Println("serve:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	run := runInfo{started: time.Now()}
+	var results []Result
+	if *adaptive {
+		fmt.Printf("\n\nStarting adaptive CloudDNS Benchmarks, using %d random domains per round\n", *roundSize)
+		results = adaptiveGenerator()
+	} else {
+		fmt.Printf("\n\nStarting CloudDNS Benchmarks, using %d random domains\n", *numOfQueries)
+		results = generator()
+	}
+	if agent != nil {
+		if err := agent.failed(); err != nil {
+			fmt.Println("agent:", err)
+			os.Exit(1)
+		}
+	}
 
-	generateReport(results)
+	var baselines map[string]networkRTT
+	if *baseline {
+		baselines = measureBaselines(benchServers())
+	}
+
+	var columns []column
+	if *nxCheck {
+		columns = append(columns, nxdomainColumn(benchServers()))
+	}
+	if *dnssecCheck {
+		columns = append(columns, dnssecColumn(benchServers()))
+	}
+	if *ecsCheck {
+		columns = append(columns, ecsColumn(benchServers()))
+	}
+
+	run.finished = time.Now()
+	report := generateReport(results, baselines, columns...)
+	if *cdnCheck {
+		printCDN(results)
+	}
+	if *plotFile != "" {
+		if err := writePlot(*plotFile, report, results); err != nil {
+			fmt.Println("plot:", err)
+		} else {
+			fmt.Printf("\nPlots written to %v\n", *plotFile)
+		}
+	}
+	if *format == "html" {
+		if err := writeHTML(*output, report, results, run); err != nil {
+			fmt.Println("html:", err)
+		} else {
+			fmt.Printf("\nHTML report written to %v\n", *output)
+		}
+	}
+
+	if len(testDomains) > 0 {
+		servers := benchServers()
+		printFilterProfile(servers, testDomains, checkFiltering(servers, testDomains))
+	}
+
+	if *audit {
+		servers := benchServers()
+		echo, upstream := caseColumns(servers)
+		printHardening(servers, echo, upstream, sourceColumn(servers))
+	}
 
 	fmt.Print("\nPress ENTER to exit \n")
 	scanner := bufio.NewScanner(os.Stdin)
@@ -64,95 +226,178 @@ func main() {
 }
 
 func generator() []Result {
-	var results []Result
-	var hosts List
+	return runQueries(benchmark{
+		hosts:    selectHosts(*numOfQueries),
+		servers:  benchServers(),
+		local:    true,
+		protocol: *protocol,
+	})
+}
+
+func selectHosts(num int) List {
 	if *typeofHost == "top" {
-		hosts = Top.randomSelect(*numOfQueries)
-	} else {
-		hosts = Hosts.randomSelect(*numOfQueries)
+		return Top.randomSelect(num)
 	}
+	return Hosts.randomSelect(num)
+}
 
-	cloudResults := make(chan Result)
-	cloudQuries := buildCloudQuries(hosts, Servers, cloudResults)
-	var wg sync.WaitGroup
-	// var localwg sync.WaitGroup
-	wg.Add(len(cloudQuries))
+// benchmark is what one run of queries asks.
+type benchmark struct {
+	hosts    List
+	servers  []string
+	local    bool     // also query the local resolver
+	protocol string   // udp, tcp or tcp-tls
+	progress observer // told of each query, the global progress if nil
+}
 
-	for _, v := range cloudQuries {
-		go dnsworker(v)
+// checkProtocol makes sure p is a protocol queries can be sent over.
+func checkProtocol(p string) error {
+	switch p {
+	case "udp", "tcp", "tcp-tls":
+		return nil
 	}
+	return fmt.Errorf("protocol %q: want udp, tcp or tcp-tls", p)
+}
 
-	queueLength := len(cloudQuries)
-	queuePosition := 0
-	for i := 0; i < *numOResolvers; i++ {
-		if queuePosition < queueLength {
-			cloudQuries[queuePosition].wait <- false
-			queuePosition++
-		}
+func checkOrder(o string) error {
+	switch o {
+	case "random", "roundrobin", "host":
+		return nil
 	}
+	return fmt.Errorf("order %q: want random, roundrobin or host", o)
+}
 
-	localResults := make(chan Result)
-	localQuries := buildLocalQuries(hosts, localResults)
+// runQueries looks up every host on every server, and on the local
+// resolver when local is set. Cloud and local lookups share one queue and
+// are interleaved, so changing network conditions land on every server
+// alike.
+func runQueries(b benchmark) []Result {
+	var results []Result
+	watch := b.progress
+	if watch == nil {
+		watch = progress
+	}
 
-	localQueueLength := len(localQuries)
-	localQueuePosition := 0
+	resp := make(chan Result)
+	quries := buildCloudQuries(b.hosts, b.servers, b.protocol, resp)
+	var localQuries []Query
+	if b.local {
+		localQuries = buildLocalQuries(b.hosts, resp)
+	}
+	quries = interleave(quries, localQuries, len(b.hosts), *order)
+	watch.start(quries)
 
-	for _, v := range localQuries {
-		go localLookup(v)
+	var wg sync.WaitGroup
+	wg.Add(len(quries))
+
+	for _, v := range quries {
+		go v.lookup(v)
 	}
 
-	// Running Cloud DNS
-	go func() {
-		for {
-			select {
-			case r := <-cloudResults:
-				fmt.Println(r)
-				if queuePosition < queueLength {
-					cloudQuries[queuePosition].wait <- false
-					queuePosition++
-				}
-				results = append(results, r)
-				wg.Done()
-			case l := <-localResults:
-				fmt.Println(l)
-				if localQueuePosition < localQueueLength {
-					localQuries[localQueuePosition].wait <- false
-					localQueuePosition++
-				}
-				results = append(results, l)
-				wg.Done()
+	limit := *perServer
+	if limit <= 0 {
+		distinct := make(map[string]bool)
+		for _, q := range quries {
+			distinct[q.server] = true
+		}
+		limit = 1
+		if len(distinct) > 0 {
+			limit = *numOResolvers / len(distinct)
+		}
+		if limit < 1 {
+			limit = 1
+		}
+	}
+
+	// release the next queries in order whose server is below its limit
+	var mu sync.Mutex
+	pending := quries
+	inflight := make(map[string]int)
+	running := 0
+	release := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := 0; i < len(pending) && running < *numOResolvers; {
+			q := pending[i]
+			if inflight[q.server] >= limit {
+				i++
+				continue
 			}
+			inflight[q.server]++
+			running++
+			pending = append(pending[:i:i], pending[i+1:]...)
+			q.wait <- false
+		}
+	}
+	release()
+
+	go func() {
+		for r := range resp {
+			watch.result(r)
+			mu.Lock()
+			inflight[r.server]--
+			running--
+			mu.Unlock()
+			release()
+			results = append(results, r)
+			wg.Done()
 		}
 	}()
 
 	wg.Wait()
+	close(resp)
+	watch.done()
+	return results
 
-	// Running Local Resolver
-	fmt.Println("Now Running Local")
-	wg.Add(len(localQuries))
+}
 
-	for i := 0; i < 3; i++ {
-		if localQueuePosition < localQueueLength {
-			localQuries[localQueuePosition].wait <- false
-			localQueuePosition++
+// interleave merges the host-major cloud queries and the local queries
+// into a single dispatch order. With "roundrobin" each host's queries are
+// rotated so no server is always asked first, "random" shuffles them all
+// and "host" keeps the original host-major order.
+func interleave(cloud, local []Query, numHosts int, order string) []Query {
+	if numHosts == 0 {
+		return nil
+	}
+	perHost := len(cloud) / numHosts
+	localPerHost := len(local) / numHosts
+	var quries []Query
+	for i := 0; i < numHosts; i++ {
+		row := append([]Query(nil), cloud[i*perHost:(i+1)*perHost]...)
+		row = append(row, local[i*localPerHost:(i+1)*localPerHost]...)
+		if order == "roundrobin" && len(row) > 0 {
+			k := i % len(row)
+			row = append(row[k:], row[:k]...)
 		}
+		quries = append(quries, row...)
 	}
-
-	wg.Wait()
-	return results
-
+	if order == "random" {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		r.Shuffle(len(quries), func(i, j int) {
+			quries[i], quries[j] = quries[j], quries[i]
+		})
+	}
+	return quries
 }
 
-func buildCloudQuries(hosts, servers []string, resp chan Result) []Query {
+func buildCloudQuries(hosts, servers []string, protocol string, resp chan Result) []Query {
 	var quries []Query
 	for i := range hosts {
 		for j := range servers {
-			var q Query
-			q.wait = make(chan bool)
-			q.result = resp
-			q.host = hosts[i]
-			q.server = servers[j]
-			quries = append(quries, q)
+			for _, source := range sources() {
+				if source != "" && sourceAddr(source, servers[j]) == nil {
+					continue
+				}
+				var q Query
+				q.wait = make(chan bool)
+				q.result = resp
+				q.host = hosts[i]
+				q.server = servers[j]
+				q.source = source
+				q.protocol = protocol
+				q.lookup = dnsworker
+				quries = append(quries, q)
+			}
 		}
 	}
 	return quries
@@ -161,11 +406,32 @@ func buildCloudQuries(hosts, servers []string, resp chan Result) []Query {
 func buildLocalQuries(hosts []string, resp chan Result) []Query {
 	var quries []Query
 	for i := range hosts {
-		var q Query
-		q.wait = make(chan bool)
-		q.result = resp
-		q.host = hosts[i]
-		quries = append(quries, q)
+		seen := make(map[string]bool)
+		for _, s := range stubs() {
+			for _, source := range sources() {
+				var q Query
+				q.wait = make(chan bool)
+				q.result = resp
+				q.host = hosts[i]
+				q.server = s.name
+				q.source = source
+				q.resolver = s.resolver
+				if source != "" {
+					if sourceAddr(source, s.name) == nil {
+						continue
+					}
+					// only the pure Go resolver can be bound, say so
+					q.server = localServer + "-go"
+					q.resolver = boundResolver(source)
+				}
+				if seen[label(q.server, source)] {
+					continue
+				}
+				seen[label(q.server, source)] = true
+				q.lookup = localLookup
+				quries = append(quries, q)
+			}
+		}
 	}
 	return quries
 }
@@ -184,96 +450,226 @@ func (l List) randomSelect(num int) []string {
 }
 
 type Result struct {
-	server string
-	host   string
-	rtt    time.Duration
-	errors int
-	ok     bool
+	server   string
+	source   string // source address or interface queried from, if chosen
+	host     string
+	at       time.Time // when the first attempt was sent
+	rtt      time.Duration
+	errors   int
+	ok       bool
+	rcode    int
+	answers  []string // sorted addresses from the answer section
+	first    string   // address listed first, which a client connects to
+	instance string   // anycast instance that answered, with -identify
+
+	attempts  []attempt     // every try, in order
+	effective time.Duration // from the first try to the answer, including retries
+}
+
+// name identifies the server and, when several were chosen, the source
+// a result came through.
+func (r Result) name() string {
+	return label(r.server, r.source)
+}
+
+func label(server, source string) string {
+	if source == "" {
+		return server
+	}
+	return server + " via " + source
 }
 
 func (r Result) String() string {
 	if r.ok {
-		return fmt.Sprintf("server: %15v host: %31v, time [%6v]ms", r.server, r.host, r.rtt.Nanoseconds()/1e6)
+		return fmt.Sprintf("server: %15v host: %31v, time [%6v]ms", r.name(), r.host, r.rtt.Nanoseconds()/1e6)
 	} else {
-		return fmt.Sprintf("server: %15v host: %31v, error [timeout]", r.server, r.host)
+		if r.rcode != dns.RcodeSuccess {
+			return fmt.Sprintf("server: %15v host: %31v, error [%v]", r.name(), r.host, dns.RcodeToString[r.rcode])
+		}
+		return fmt.Sprintf("server: %15v host: %31v, error [timeout]", r.name(), r.host)
 	}
 }
 
+// localServer names the results of lookups made through the OS resolver
+// stub, which also takes in /etc/hosts, search domains and nsswitch.
+const localServer = "stub"
+
 type Query struct {
-	server string
-	host   string
-	wait   chan bool
-	result chan Result
+	server   string
+	host     string
+	wait     chan bool
+	result   chan Result
+	lookup   Querier
+	source   string
+	protocol string // udp, tcp or tcp-tls, for dnsworker
+
+	// resolver used by localLookup
+	resolver *net.Resolver
 }
 
 type Querier func(Query)
 
 func dnsworker(query Query) {
 	<-query.wait
-	config := new(dns.ClientConfig)
-	config.Port = "53"
-	config.Ndots = 1
-	config.Timeout = 60
-	config.Attempts = 5
-
 	var r Result
 	r.server = query.server
+	r.source = query.source
 	r.host = query.host
 	r.ok = false
 
-	c := new(dns.Client)
-	c.DialTimeout = 8 * time.Second
-	c.ReadTimeout = 8 * time.Second
+	c := queryClient(query.protocol, query)
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(query.host), dns.TypeA)
 	m.RecursionDesired = true
+	setECS(m)
+	if *identify {
+		requestNSID(m)
+	}
 
-	for !r.ok {
-		ans, rtt, _ := c.Exchange(m, net.JoinHostPort(query.server, config.Port))
-		if ans == nil {
-			// fmt.Printf("Host %s DNS server %s error: [%v]\n", query.host, query.server, err)
+	start := time.Now()
+	r.at = start
+	addr := serverAddr(query.server, serverPort(query.protocol))
+	for try := 0; try < *attempts && !r.ok; try++ {
+		time.Sleep(backoffDelay(try))
+		sent := time.Now()
+		ans, rtt, err := c.Exchange(m, addr)
+		if err == nil && ans.Truncated && c.Net == "udp" {
+			// the answer didn't fit, ask again over TCP as a stub would
+			r.attempts = append(r.attempts, attempt{attemptTruncated, rtt})
+			ans, _, err = queryClient("tcp", query).Exchange(m, addr)
+			rtt = time.Since(sent)
+		}
+		if ans != nil {
+			r.rcode = ans.Rcode
+		}
+		switch {
+		case ans == nil:
+			r.attempts = append(r.attempts, attempt{outcomeOf(err), time.Since(sent)})
 			r.errors++
-			if r.errors > config.Attempts {
-				query.result <- r
-				return
-			}
-		} else {
+		case ans.Rcode != dns.RcodeSuccess && ans.Rcode != dns.RcodeNameError:
+			r.attempts = append(r.attempts, attempt{dns.RcodeToString[ans.Rcode], rtt})
+			r.errors++
+		default:
+			r.attempts = append(r.attempts, attempt{attemptOK, rtt})
 			r.rtt = rtt
+			r.effective = time.Since(start)
 			r.ok = true
+			r.answers = addresses(ans)
+			r.first = firstAddress(ans)
+			if *identify {
+				if r.instance = nsidOf(ans); r.instance == "" {
+					r.instance = chaosIdentity(query)
+				}
+			}
 		}
 	}
 	query.result <- r
 }
 
+// queryClient returns a client sending query over network from its source.
+func queryClient(network string, query Query) *dns.Client {
+	c := new(dns.Client)
+	c.Net = network
+	c.DialTimeout = *timeout
+	c.ReadTimeout = *timeout
+	if query.source != "" {
+		c.Dialer = dialer(network, query.source, query.server, c.DialTimeout)
+	}
+	return c
+}
+
+// serverPort returns the port servers answer queries over protocol on.
+func serverPort(protocol string) string {
+	if protocol == "tcp-tls" {
+		return "853"
+	}
+	return "53"
+}
+
+// addresses returns the sorted A and AAAA addresses in the answer of m.
+func addresses(m *dns.Msg) []string {
+	var addrs []string
+	for _, rr := range m.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, rr.A.String())
+		case *dns.AAAA:
+			addrs = append(addrs, rr.AAAA.String())
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// firstAddress returns the first A or AAAA address in the answer section.
+func firstAddress(m *dns.Msg) string {
+	for _, rr := range m.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			return rr.A.String()
+		case *dns.AAAA:
+			return rr.AAAA.String()
+		}
+	}
+	return ""
+}
+
+// serverAddr returns the address of server, adding port unless it already
+// has one.
+func serverAddr(server, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, port)
+}
+
 func localLookup(query Query) {
 	<-query.wait
 	var r Result
 	r.host = query.host
-	r.server = "Current DNS"
-	r.ok = true
-	var retries int
-	var lookuptime time.Duration
-
-	for retries = 0; retries < 5; retries++ {
-		start := time.Now()
-		_, err := net.LookupHost(r.host)
-		end := time.Now()
-		lookuptime = end.Sub(start)
-		if err == nil {
-			r.rtt = lookuptime
-			query.result <- r
-			return
-		}
-	}
-	lookuptime = 0
-	r.errors = retries
+	r.server = query.server
+	r.source = query.source
 	r.ok = false
 
+	start := time.Now()
+	r.at = start
+	for try := 0; try < *attempts && !r.ok; try++ {
+		time.Sleep(backoffDelay(try))
+		sent := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		addrs, err := query.resolver.LookupHost(ctx, r.host)
+		cancel()
+		lookuptime := time.Since(sent)
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			// an answer all the same, like NXDOMAIN from dnsworker
+			err = nil
+		}
+		if err != nil {
+			r.attempts = append(r.attempts, attempt{outcomeOf(err), lookuptime})
+			r.errors++
+			continue
+		}
+		r.attempts = append(r.attempts, attempt{attemptOK, lookuptime})
+		r.rtt = lookuptime
+		r.effective = time.Since(start)
+		r.ok = true
+		// only the IPv4 addresses compare with the A queries
+		for _, a := range addrs {
+			if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+				r.answers = append(r.answers, a)
+			}
+		}
+		if len(r.answers) > 0 {
+			r.first = r.answers[0]
+		}
+		sort.Strings(r.answers)
+	}
 	query.result <- r
 
 }
 
 type roundTrip struct {
+	n int
 	// in milliseconds
 	min float64
 	max float64
@@ -284,37 +680,104 @@ type roundTrip struct {
 type Times []time.Duration
 
 type record struct {
-	server string
-	times  roundTrip
-	errors int
+	server    string
+	source    string
+	times     roundTrip
+	samples   Times
+	first     roundTrip // of lookups answered on the first attempt
+	effective roundTrip // including the time lost to retries
+	loss      loss
+	tier      int
+	errors    int
+	agreement float64 // percentage of answers agreeing with the other servers
+}
+
+func (r record) label() string {
+	return label(r.server, r.source)
+}
+
+// failRate is the percentage of lookups that failed.
+func (r record) failRate() float64 {
+	return 100 * float64(r.errors) / float64(len(r.samples)+r.errors)
 }
 
 type Report []record
 
-func generateReport(results []Result) {
+// buildReport summarises the results of each server, ranked and split
+// into tiers, along with how often their answers agree with the others.
+func buildReport(results []Result) (Report, map[string]*agreement) {
 	s := make(map[string]Times)
+	failed := make(map[string]int)
+	names := make(map[string]Result)
+	byName := make(map[string][]Result)
 	for _, v := range results {
+		names[v.name()] = v
+		byName[v.name()] = append(byName[v.name()], v)
 		if v.ok {
-			s[v.server] = append(s[v.server], v.rtt)
+			s[v.name()] = append(s[v.name()], v.rtt)
+		} else {
+			failed[v.name()]++
 		}
 	}
+	agreements := checkAnswers(results)
 	var report Report
-	for k, v := range s {
+	// servers that failed every lookup have no samples but still get a row
+	for k := range byName {
+		v := s[k]
 		times := calcRoundTrip(v)
 		// fmt.Printf("%15v    %v\n", k, times)
 		var r record
-		r.server = k
+		r.server = names[k].server
+		r.source = names[k].source
 		r.times = times
+		r.samples = v
+		r.first = calcRoundTrip(firstTry(byName[k]))
+		r.effective = calcRoundTrip(effective(byName[k]))
+		r.loss = lossOf(byName[k])
+		r.errors = failed[k]
+		r.agreement = math.NaN()
+		if a := agreements[k]; a != nil {
+			r.agreement = a.rate()
+		}
 		report = append(report, r)
 	}
 	sort.Sort(report)
+	assignTiers(report, *alpha)
+	return report, agreements
+}
+
+func generateReport(results []Result, baselines map[string]networkRTT, columns ...column) Report {
+	report, agreements := buildReport(results)
 
-	fmt.Println("\n\nResults; Ordered by lowest average response time")
+	fmt.Printf("\n\nResults; Ordered by first try response time plus loss x %v\n", *lossPenalty)
+	fmt.Printf("Servers in the same tier are not significantly different (Mann-Whitney U and loss z-test, p >= %v)\n", *alpha)
 
 	for k, v := range report {
-		fmt.Printf("#%2d %15v %v\n", k+1, v.server, v.times)
+		fmt.Printf("#%2d %15v tier[%2d] score[%6.1f] %vfirst[%6.1f]ms eff[%6.1f]ms %v n[%4d] agree[%5.1f]%%",
+			k+1, v.label(), v.tier, v.score(), v.times, v.first.avg, v.effective.avg, v.loss, len(v.samples), v.agreement)
+		for _, c := range columns {
+			value, ok := c.values[v.server]
+			if !ok {
+				value = "-"
+			}
+			fmt.Printf(" %s[%s]", c.name, value)
+		}
+		fmt.Println()
 	}
-
+	printDisagreements(report, agreements)
+	if *identify {
+		printInstances(report, results)
+	}
+	printDualStack(report)
+	printSources(report)
+	printBaselines(report, results, baselines)
+	printGeo(report, results)
+	for _, c := range columns {
+		if c.name == "nxdomain" {
+			printFlagged("Servers rewriting NXDOMAIN, not recommended", c, "HIJACK")
+		}
+	}
+	return report
 }
 
 func (r Report) Len() int {
@@ -322,7 +785,7 @@ func (r Report) Len() int {
 }
 
 func (r Report) Less(i, j int) bool {
-	return r[i].times.avg < r[j].times.avg
+	return r[i].score() < r[j].score()
 }
 
 func (r Report) Swap(i, j int) {
@@ -332,6 +795,11 @@ func (r Report) Swap(i, j int) {
 func calcRoundTrip(times Times) roundTrip {
 
 	var r roundTrip
+	r.n = len(times)
+	if r.n == 0 {
+		nan := math.NaN()
+		return roundTrip{min: nan, max: nan, avg: nan, std: nan}
+	}
 	r.avg = avg(times)
 	r.min = min(times)
 	r.max = max(times)
@@ -0,0 +1,306 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// mockHosts returns n names the mock resolvers answer.
+func mockHosts(n int) List {
+	return append(List(nil), Top[:n]...)
+}
+
+// quick makes failed attempts cheap for the rest of the test.
+func quick(t *testing.T) {
+	setFlag(t, "timeout", "100ms")
+	setFlag(t, "backoff", "0")
+	setFlag(t, "order", "roundrobin")
+}
+
+func TestRunQueriesPerServerLimit(t *testing.T) {
+	quick(t)
+	setFlag(t, "r", "4")
+	setFlag(t, "c", "0")
+	a := &mockResolver{latency: 20 * time.Millisecond}
+	b := &mockResolver{latency: 20 * time.Millisecond}
+	servers := []string{startMock(t, a, "127.0.0.1"), startMock(t, b, "127.0.0.1")}
+
+	results := runQueries(benchmark{hosts: mockHosts(10), servers: servers, protocol: "udp", progress: discard{}})
+	if len(results) != 20 {
+		t.Fatalf("got %d results, want 20", len(results))
+	}
+	for _, r := range results {
+		if !r.ok {
+			t.Errorf("%v failed", r)
+		}
+	}
+	// -r 4 over two servers leaves each at most two queries at once
+	for _, m := range []*mockResolver{a, b} {
+		if peak := atomic.LoadInt32(&m.peak); peak < 1 || peak > 2 {
+			t.Errorf("server saw %d queries at once, want 1 or 2", peak)
+		}
+	}
+
+	setFlag(t, "c", "1")
+	atomic.StoreInt32(&a.peak, 0)
+	atomic.StoreInt32(&b.peak, 0)
+	runQueries(benchmark{hosts: mockHosts(10), servers: servers, protocol: "udp", progress: discard{}})
+	for _, m := range []*mockResolver{a, b} {
+		if peak := atomic.LoadInt32(&m.peak); peak != 1 {
+			t.Errorf("server saw %d queries at once with -c 1, want 1", peak)
+		}
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	hosts := []string{"a", "b", "c"}
+	servers := []string{"s1", "s2"}
+	cloud := buildCloudQuries(hosts, servers, "udp", nil)
+	local := []Query{{server: "stub", host: "a"}, {server: "stub", host: "b"}, {server: "stub", host: "c"}}
+
+	order := func(qs []Query) []string {
+		var got []string
+		for _, q := range qs {
+			got = append(got, q.host+"@"+q.server)
+		}
+		return got
+	}
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{"host", []string{"a@s1", "a@s2", "a@stub", "b@s1", "b@s2", "b@stub", "c@s1", "c@s2", "c@stub"}},
+		{"roundrobin", []string{"a@s1", "a@s2", "a@stub", "b@s2", "b@stub", "b@s1", "c@stub", "c@s1", "c@s2"}},
+	}
+	for _, tt := range tests {
+		got := order(interleave(cloud, local, len(hosts), tt.order))
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.order, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.order, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, o := range []string{"random", "roundrobin", "host"} {
+		if err := checkOrder(o); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, o := range []string{"", "Random", "round-robin"} {
+		if checkOrder(o) == nil {
+			t.Errorf("order %q accepted", o)
+		}
+	}
+
+	seen := make(map[string]int)
+	for _, q := range order(interleave(cloud, local, len(hosts), "random")) {
+		seen[q]++
+	}
+	if len(seen) != 9 {
+		t.Errorf("random order has %d distinct queries, want 9", len(seen))
+	}
+	for q, n := range seen {
+		if n != 1 {
+			t.Errorf("random order sends %s %d times", q, n)
+		}
+	}
+}
+
+func TestRetryDropped(t *testing.T) {
+	quick(t)
+	setFlag(t, "attempts", "10")
+	m := &mockResolver{drop: 0.3}
+	server := startMock(t, m, "127.0.0.1")
+
+	results := runQueries(benchmark{hosts: mockHosts(30), servers: []string{server}, protocol: "udp", progress: discard{}})
+	retried := 0
+	for _, r := range results {
+		if !r.ok {
+			t.Errorf("%v failed after %d attempts", r, len(r.attempts))
+			continue
+		}
+		if n := len(r.attempts); n > 1 {
+			retried++
+			for _, a := range r.attempts[:n-1] {
+				if a.outcome != attemptTimeout {
+					t.Errorf("%v: dropped attempt recorded as %q", r, a.outcome)
+				}
+			}
+			if r.effective < r.rtt+100*time.Millisecond {
+				t.Errorf("%v: effective %v doesn't include the lost attempt", r, r.effective)
+			}
+		}
+		if last := r.attempts[len(r.attempts)-1]; last.outcome != attemptOK {
+			t.Errorf("%v: last attempt %q, want ok", r, last.outcome)
+		}
+	}
+	if retried == 0 {
+		t.Error("no query was retried")
+	}
+	if l := lossOf(results); l.lost == 0 || l.lost >= l.sent {
+		t.Errorf("lost %d of %d attempts", l.lost, l.sent)
+	}
+}
+
+func TestRetryServfail(t *testing.T) {
+	quick(t)
+	setFlag(t, "attempts", "3")
+	server := startMock(t, &mockResolver{servfail: 1}, "127.0.0.1")
+
+	results := runQueries(benchmark{hosts: mockHosts(5), servers: []string{server}, protocol: "udp", progress: discard{}})
+	for _, r := range results {
+		if r.ok || r.errors != 3 || len(r.attempts) != 3 {
+			t.Errorf("%v: ok %v after %d errors and %d attempts, want 3 failures", r, r.ok, r.errors, len(r.attempts))
+		}
+		for _, a := range r.attempts {
+			if a.outcome != "SERVFAIL" {
+				t.Errorf("%v: attempt %q, want SERVFAIL", r, a.outcome)
+			}
+		}
+	}
+	if l := lossOf(results); l.lost != 0 {
+		t.Errorf("SERVFAIL answers counted as %d lost", l.lost)
+	}
+}
+
+func TestTruncatedRetriedOverTCP(t *testing.T) {
+	quick(t)
+	server := startMock(t, &mockResolver{truncate: 1}, "127.0.0.1")
+
+	results := runQueries(benchmark{hosts: mockHosts(5), servers: []string{server}, protocol: "udp", progress: discard{}})
+	for _, r := range results {
+		if !r.ok || len(r.answers) == 0 {
+			t.Errorf("%v: ok %v with %d answers, want the TCP answer", r, r.ok, len(r.answers))
+		}
+		if len(r.attempts) != 2 || r.attempts[0].outcome != attemptTruncated || r.attempts[1].outcome != attemptOK {
+			t.Errorf("%v: attempts %v, want truncated then ok", r, r.attempts)
+		}
+	}
+}
+
+// synthetic returns n results for server taking rtt, the first lost of
+// them timing out once before being answered.
+func synthetic(server string, n int, rtt time.Duration, lost int) []Result {
+	var results []Result
+	for i := 0; i < n; i++ {
+		r := Result{server: server, host: "example.com", rtt: rtt, effective: rtt, ok: true}
+		if i < lost {
+			r.attempts = append(r.attempts, attempt{attemptTimeout, time.Second})
+			r.effective += time.Second
+		}
+		r.attempts = append(r.attempts, attempt{attemptOK, rtt})
+		results = append(results, r)
+	}
+	return results
+}
+
+func TestBuildReportRanking(t *testing.T) {
+	setFlag(t, "alpha", "0.05")
+	var results []Result
+	results = append(results, synthetic("slow", 30, 50*time.Millisecond, 0)...)
+	results = append(results, synthetic("fast", 30, 10*time.Millisecond, 0)...)
+	results = append(results, synthetic("also-fast", 30, 10*time.Millisecond, 0)...)
+	results = append(results, synthetic("lossy", 30, 10*time.Millisecond, 20)...)
+
+	report, _ := buildReport(results)
+	if len(report) != 4 {
+		t.Fatalf("got %d records, want 4", len(report))
+	}
+	rank := make(map[string]record)
+	for _, r := range report {
+		rank[r.server] = r
+	}
+	if a, b := rank["fast"], rank["also-fast"]; a.tier != 1 || b.tier != 1 {
+		t.Errorf("equal servers in tiers %d and %d, want both in 1", a.tier, b.tier)
+	}
+	if r := rank["slow"]; r.tier <= 1 {
+		t.Errorf("slow server in tier %d, want below the fast ones", r.tier)
+	}
+	if r := rank["lossy"]; r.tier <= 1 || r.score() <= rank["fast"].score() {
+		t.Errorf("lossy server in tier %d with score %v, want ranked below the fast ones", r.tier, r.score())
+	}
+	for i := 1; i < len(report); i++ {
+		if report[i].score() < report[i-1].score() || report[i].tier < report[i-1].tier {
+			t.Errorf("report out of order at #%d: %v", i+1, report[i].label())
+		}
+	}
+	if r := rank["fast"]; r.times.n != 30 || math.Abs(r.times.avg-10) > 1e-9 {
+		t.Errorf("fast server averaged %v over %d, want 10 over 30", r.times.avg, r.times.n)
+	}
+}
+
+func TestBuildReportAgainstMocks(t *testing.T) {
+	quick(t)
+	setFlag(t, "r", "8")
+	servers := []string{
+		startMock(t, &mockResolver{latency: 2 * time.Millisecond}, "127.0.0.1"),
+		startMock(t, &mockResolver{latency: 40 * time.Millisecond}, "127.0.0.1"),
+	}
+	results := runQueries(benchmark{hosts: mockHosts(20), servers: servers, protocol: "udp", progress: discard{}})
+	report, agreements := buildReport(results)
+	if len(report) != 2 {
+		t.Fatalf("got %d records, want 2", len(report))
+	}
+	if report[0].server != servers[0] || report[1].tier <= report[0].tier {
+		t.Errorf("got %v tier %d then %v tier %d, want the fast server alone in the first tier",
+			report[0].server, report[0].tier, report[1].server, report[1].tier)
+	}
+	for _, r := range report {
+		if r.agreement != 100 {
+			t.Errorf("%v agrees %v%%, want 100%% as mocks answer alike", r.server, r.agreement)
+		}
+	}
+	if len(agreements) != 2 {
+		t.Errorf("got agreement for %d servers, want 2", len(agreements))
+	}
+}
+
+func TestBuildReportFailedServers(t *testing.T) {
+	var results []Result
+	results = append(results, synthetic("slow", 10, 80*time.Millisecond, 0)...)
+	for i := 0; i < 10; i++ {
+		results = append(results, Result{server: "dead", host: "example.com", errors: 3,
+			attempts: []attempt{{attemptTimeout, time.Second}, {attemptTimeout, time.Second}, {attemptTimeout, time.Second}}})
+		results = append(results, Result{server: "broken", host: "example.com", errors: 1, rcode: 2,
+			attempts: []attempt{{"SERVFAIL", time.Millisecond}}})
+	}
+
+	report, _ := buildReport(results)
+	if len(report) != 3 {
+		t.Fatalf("got %d records, want every server including those that never answered", len(report))
+	}
+	if report[0].server != "slow" || report[0].tier != 1 {
+		t.Errorf("got %v in tier %d first, want the only server that answered", report[0].server, report[0].tier)
+	}
+	for _, r := range report[1:] {
+		if !math.IsInf(r.score(), 1) || r.tier < 2 || r.errors != 10 || len(r.samples) != 0 {
+			t.Errorf("%v: score %v tier %d errors %d, want last with an infinite score", r.server, r.score(), r.tier, r.errors)
+		}
+		if r.failRate() != 100 {
+			t.Errorf("%v: fail rate %v, want 100", r.server, r.failRate())
+		}
+	}
+}
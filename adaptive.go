@@ -0,0 +1,188 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	roundSize  = flag.Int("round", 5, "Number of domains queried per round in adaptive mode")
+	topN       = flag.Int("top", 5, "Number of leading servers that must be ranked with confidence in adaptive mode")
+	ciWidth    = flag.Float64("ci", 0.05, "Target 95% confidence interval half width, as a fraction of the mean, in adaptive mode")
+	budget     = flag.Duration("budget", 5*time.Minute, "Maximum run time in adaptive mode")
+	maxQueries = flag.Int("maxq", 0, "Maximum number of queries in adaptive mode, 0 for no limit")
+)
+
+// fewest samples before a confidence interval is trusted
+const minSamples = 5
+
+type estimate struct {
+	server   string
+	source   string
+	attempts int
+	n        int
+	mean     float64
+	hw       float64 // 95% confidence interval half width
+}
+
+func (e estimate) lower() float64 { return e.mean - e.hw }
+func (e estimate) upper() float64 { return e.mean + e.hw }
+
+// adaptiveGenerator queries in rounds, only revisiting servers whose
+// ranking is still uncertain, until every server has settled or the
+// time or query budget runs out.
+func adaptiveGenerator() []Result {
+	var results []Result
+	start := time.Now()
+	servers := benchServers()
+	local := true
+	queries := 0
+	if *roundSize < 1 {
+		*roundSize = 1
+	}
+
+	for round := 1; ; round++ {
+		hosts := selectHosts(*roundSize)
+		n := len(hosts) * len(servers)
+		if local {
+			n += len(hosts)
+		}
+		fmt.Printf("\nRound %d: %d domains on %d servers\n", round, len(hosts), n/len(hosts))
+
+		results = append(results, runQueries(benchmark{
+			hosts:    hosts,
+			servers:  servers,
+			local:    local,
+			protocol: *protocol,
+		})...)
+		queries += n
+
+		var reason string
+		servers, local = unsettled(results)
+		switch {
+		case len(servers) == 0 && !local:
+			reason = "rankings are stable"
+		case time.Since(start) >= *budget:
+			reason = "time budget exhausted"
+		case *maxQueries > 0 && queries >= *maxQueries:
+			reason = "query budget exhausted"
+		}
+		if reason != "" {
+			fmt.Printf("\nAdaptive sampling stopped after %d rounds, %d queries in %v: %s\n",
+				round, queries, time.Since(start).Round(time.Second), reason)
+			return results
+		}
+	}
+}
+
+// unsettled returns the cloud servers that still need sampling through
+// any source, and whether the local resolver does.
+func unsettled(results []Result) ([]string, bool) {
+	estimates := estimateAll(results)
+
+	// the slowest of the top servers marks the cut off, anything whose
+	// interval lies wholly above it can't make the top.
+	var cutoff float64
+	if len(estimates) > 0 {
+		i := *topN - 1
+		if i >= len(estimates) {
+			i = len(estimates) - 1
+		}
+		if i >= 0 {
+			cutoff = estimates[i].upper()
+		}
+	}
+
+	var servers []string
+	seen := make(map[string]bool)
+	local := false
+	for rank, e := range estimates {
+		if settled(e, rank, cutoff) {
+			continue
+		}
+		if isStub(e.server) {
+			local = true
+		} else if !seen[e.server] {
+			seen[e.server] = true
+			servers = append(servers, e.server)
+		}
+	}
+	return servers, local
+}
+
+func settled(e estimate, rank int, cutoff float64) bool {
+	if e.n < minSamples {
+		// give up on servers that keep failing
+		return e.attempts >= 2*minSamples && e.n < 2
+	}
+	if e.hw <= *ciWidth*e.mean {
+		return true
+	}
+	return rank >= *topN && e.lower() > cutoff
+}
+
+// estimateAll returns the mean response time and confidence interval for
+// every server through every source, fastest first.
+func estimateAll(results []Result) []estimate {
+	s := make(map[string]Times)
+	attempts := make(map[string]int)
+	names := make(map[string]Result)
+	for _, v := range results {
+		names[v.name()] = v
+		attempts[v.name()]++
+		if v.ok {
+			s[v.name()] = append(s[v.name()], v.rtt)
+		}
+	}
+
+	var estimates []estimate
+	for name, n := range attempts {
+		e := estimate{server: names[name].server, source: names[name].source, attempts: n}
+		ms := s[name].millis()
+		e.n = len(ms)
+		if e.n > 0 {
+			var sum float64
+			for _, v := range ms {
+				sum += v
+			}
+			e.mean = sum / float64(e.n)
+		}
+		if e.n > 1 {
+			var ss float64
+			for _, v := range ms {
+				ss += (v - e.mean) * (v - e.mean)
+			}
+			e.hw = 1.96 * math.Sqrt(ss/float64(e.n-1)) / math.Sqrt(float64(e.n))
+		} else {
+			e.hw = math.Inf(1)
+		}
+		estimates = append(estimates, e)
+	}
+	sort.Slice(estimates, func(i, j int) bool {
+		if estimates[i].n == 0 || estimates[j].n == 0 {
+			return estimates[i].n > estimates[j].n
+		}
+		return estimates[i].mean < estimates[j].mean
+	})
+	return estimates
+}
@@ -0,0 +1,70 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEstimateAllBySource(t *testing.T) {
+	var results []Result
+	for i := 0; i < 10; i++ {
+		jitter := time.Duration(i%3) * time.Millisecond
+		results = append(results,
+			Result{server: "192.0.2.1", source: "eth0", ok: true, rtt: 10*time.Millisecond + jitter},
+			Result{server: "192.0.2.1", source: "wlan0", ok: true, rtt: 80*time.Millisecond + jitter},
+			Result{server: "192.0.2.1", source: "wlan0"})
+	}
+
+	estimates := estimateAll(results)
+	if len(estimates) != 2 {
+		t.Fatalf("got %d estimates, want one per source", len(estimates))
+	}
+	fast, slow := estimates[0], estimates[1]
+	if fast.source != "eth0" || slow.source != "wlan0" || fast.server != "192.0.2.1" || slow.server != "192.0.2.1" {
+		t.Fatalf("got %+v then %+v, want the server through eth0 first", fast, slow)
+	}
+	if math.Abs(fast.mean-11) > 0.5 || math.Abs(slow.mean-81) > 0.5 {
+		t.Errorf("means %v and %v, want about 11 and 81", fast.mean, slow.mean)
+	}
+	if fast.attempts != 10 || slow.attempts != 20 || slow.n != 10 {
+		t.Errorf("wlan0 %d of %d answered, eth0 %d tried", slow.n, slow.attempts, fast.attempts)
+	}
+}
+
+func TestUnsettledListsServersOnce(t *testing.T) {
+	setFlag(t, "ci", "0.0001")
+	setFlag(t, "top", "5")
+	var results []Result
+	for i := 0; i < 10; i++ {
+		rtt := time.Duration(10+i) * time.Millisecond
+		results = append(results,
+			Result{server: "192.0.2.1", source: "eth0", ok: true, rtt: rtt},
+			Result{server: "192.0.2.1", source: "wlan0", ok: true, rtt: rtt},
+			Result{server: localServer + "-go", source: "eth0", ok: true, rtt: rtt})
+	}
+	servers, local := unsettled(results)
+	if len(servers) != 1 || servers[0] != "192.0.2.1" {
+		t.Errorf("got servers %v, want the one server once", servers)
+	}
+	if !local {
+		t.Error("the bound stub wasn't recognised as the local resolver")
+	}
+}
@@ -0,0 +1,158 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	audit     = flag.Bool("audit", false, "Audit each server's hardening: query name case handling and response source address")
+	auditName = flag.String("audit-name", "www.wikipedia.org.", "Name queried in mixed case by the audit")
+)
+
+// mix0x20 randomises the case of the letters in name, as a resolver using
+// 0x20 encoding does to its upstream queries.
+func mix0x20(name string, r *rand.Rand) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			if r.Intn(2) == 0 {
+				b[i] = c ^ 0x20
+			}
+		}
+	}
+	return string(b)
+}
+
+// caseColumns checks whether servers echo the question name in the case it
+// was sent, which stub resolvers doing their own 0x20 checks rely on.
+// Answers whose owner names come back in a case neither sent nor lower
+// case suggest the server uses 0x20 itself and cached the upstream echo.
+func caseColumns(servers []string) (column, column) {
+	seen := column{name: "0x20", values: make(map[string]string)}
+	var mu sync.Mutex
+	echo := probeAll("case", servers, func(server string) string {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		status := "preserved"
+		upstream := "not seen"
+		for try := 0; try < 3; try++ {
+			name := mix0x20(dns.Fqdn(strings.ToLower(*auditName)), r)
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeA)
+			ans, _, err := exchange(server, m)
+			if err != nil || len(ans.Question) == 0 {
+				return "error"
+			}
+			switch got := ans.Question[0].Name; {
+			case got == name:
+			case strings.EqualFold(got, name):
+				status = "altered"
+			default:
+				return "mismatch"
+			}
+			for _, rr := range ans.Answer {
+				owner := rr.Header().Name
+				if owner != name && owner != strings.ToLower(owner) {
+					upstream = "seen"
+				}
+			}
+		}
+		mu.Lock()
+		seen.values[server] = upstream
+		mu.Unlock()
+		return status
+	})
+	return echo, seen
+}
+
+// sourceColumn sends each server a query from an unconnected socket on the
+// probe source and checks the reply comes back from the address and port
+// it was sent to.
+func sourceColumn(servers []string) column {
+	return probeAll("source", servers, func(server string) string {
+		raddr, err := net.ResolveUDPAddr("udp", serverAddr(server, "53"))
+		if err != nil {
+			return "error"
+		}
+		var lc net.ListenConfig
+		local := ":0"
+		if source := probeSource(); source != "" {
+			if src := sourceAddr(source, server); src != nil {
+				local = net.JoinHostPort(src.String(), "0")
+			}
+			if net.ParseIP(source) == nil {
+				lc.Control = bindToDevice(source)
+			}
+		}
+		conn, err := lc.ListenPacket(context.Background(), "udp", local)
+		if err != nil {
+			return "error"
+		}
+		defer conn.Close()
+
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(*auditName), dns.TypeA)
+		packed, err := m.Pack()
+		if err != nil {
+			return "error"
+		}
+		buf := make([]byte, dns.MaxMsgSize)
+		for try := 0; try < *attempts; try++ {
+			time.Sleep(backoffDelay(try))
+			if _, err := conn.WriteTo(packed, raddr); err != nil {
+				return "error"
+			}
+			conn.SetReadDeadline(time.Now().Add(*timeout))
+			for {
+				n, from, err := conn.ReadFrom(buf)
+				if err != nil {
+					break
+				}
+				reply := new(dns.Msg)
+				if reply.Unpack(buf[:n]) != nil || reply.Id != m.Id {
+					continue
+				}
+				if f, ok := from.(*net.UDPAddr); ok && f.IP.Equal(raddr.IP) && f.Port == raddr.Port {
+					return "ok"
+				}
+				return "from " + from.String()
+			}
+		}
+		return "no reply"
+	})
+}
+
+// printHardening shows the audit results for every server.
+func printHardening(servers []string, echo, upstream, source column) {
+	fmt.Println("\nHardening report")
+	fmt.Printf("%15v %-10v %-14v %v\n", "", "qname case", "0x20 upstream", "reply source")
+	for _, s := range servers {
+		fmt.Printf("%15v %-10v %-14v %v\n", s, echo.values[s], upstream.values[s], source.values[s])
+	}
+	fmt.Println("A server not seen using 0x20 may still use it; only cached answers in randomised case show it.")
+}
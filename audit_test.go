@@ -0,0 +1,125 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCaseColumns(t *testing.T) {
+	quick(t)
+	setFlag(t, "attempts", "1")
+	setFlag(t, "audit-name", Top[0])
+
+	type outcome struct{ echo, upstream string }
+	want := make(map[string]outcome)
+	for mode, o := range map[string]outcome{
+		"":      {"preserved", "not seen"},
+		"lower": {"altered", "not seen"},
+		"0x20":  {"preserved", "seen"},
+	} {
+		want[startMock(t, &mockResolver{qcase: mode}, "127.0.0.1")] = o
+	}
+	dead := startMock(t, &mockResolver{drop: 1}, "127.0.0.1")
+	want[dead] = outcome{"error", ""}
+
+	var servers []string
+	for s := range want {
+		servers = append(servers, s)
+	}
+	echo, upstream := caseColumns(servers)
+	for server, o := range want {
+		if got := echo.values[server]; got != o.echo {
+			t.Errorf("%s: case %q, want %q", server, got, o.echo)
+		}
+		if got, ok := upstream.values[server]; got != o.upstream || ok != (server != dead) {
+			t.Errorf("%s: 0x20 upstream %q, want %q", server, got, o.upstream)
+		}
+	}
+}
+
+func TestSourceColumn(t *testing.T) {
+	quick(t)
+	setFlag(t, "attempts", "2")
+
+	honest := startMock(t, &mockResolver{}, "127.0.0.1")
+	spoofed := startMock(t, &mockResolver{spoof: 1}, "127.0.0.1")
+	dead := startMock(t, &mockResolver{drop: 1}, "127.0.0.1")
+	c := sourceColumn([]string{honest, spoofed, dead})
+	if got := c.values[honest]; got != "ok" {
+		t.Errorf("honest server: %q, want ok", got)
+	}
+	if got := c.values[spoofed]; !strings.HasPrefix(got, "from 127.0.0.1:") || got == "from "+spoofed {
+		t.Errorf("spoofing server: %q, want the other address it replied from", got)
+	}
+	if got := c.values[dead]; got != "no reply" {
+		t.Errorf("silent server: %q, want no reply", got)
+	}
+}
+
+func TestSourceColumnFromSource(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only Linux answers on all of 127/8")
+	}
+	quick(t)
+	setFlag(t, "attempts", "1")
+	setFlag(t, "source-ip", "127.0.0.2")
+
+	// answer the one query, noting where it came from
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	peer := make(chan string, 1)
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := new(dns.Msg)
+		if req.Unpack(buf[:n]) != nil {
+			return
+		}
+		peer <- from.(*net.UDPAddr).IP.String()
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		packed, _ := resp.Pack()
+		conn.WriteTo(packed, from)
+	}()
+
+	server := conn.LocalAddr().String()
+	if got := sourceColumn([]string{server}).values[server]; got != "ok" {
+		t.Errorf("got %q, want ok", got)
+	}
+	select {
+	case got := <-peer:
+		if got != "127.0.0.2" {
+			t.Errorf("query sent from %v, want the source 127.0.0.2", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("query never arrived")
+	}
+}
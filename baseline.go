@@ -0,0 +1,222 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	baseline      = flag.Bool("baseline", false, "Measure the network round trip to each server, to separate distance from resolver processing time")
+	baselineCount = flag.Int("baseline-count", 5, "Number of round trips measured per server for the baseline")
+)
+
+// networkRTT is the median round trip to a server and how it was measured.
+type networkRTT struct {
+	method string
+	rtt    time.Duration
+}
+
+// measureBaselines measures the network round trip to every server from
+// every source, at most -r at a time, keyed by the label of each.
+func measureBaselines(servers []string) map[string]networkRTT {
+	baselines := make(map[string]networkRTT)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan bool, *numOResolvers)
+	for _, s := range servers {
+		for _, src := range sources() {
+			wg.Add(1)
+			go func(server, source string) {
+				defer wg.Done()
+				limit <- true
+				defer func() { <-limit }()
+				if b, ok := measureBaseline(server, source); ok {
+					mu.Lock()
+					baselines[label(server, source)] = b
+					mu.Unlock()
+				}
+			}(s, src)
+		}
+	}
+	wg.Wait()
+	return baselines
+}
+
+// measureBaseline pings server from source with ICMP echo when the OS lets
+// us open a raw socket, otherwise it times a TCP connect to port 53, then
+// 853.
+func measureBaseline(server, source string) (networkRTT, bool) {
+	host := server
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return networkRTT{}, false
+	}
+
+	if times := ping(ip, source, *baselineCount); len(times) > 0 {
+		return networkRTT{"icmp", medianDuration(times)}, true
+	}
+	ports := []string{serverAddr(server, "53")}
+	if host == server {
+		ports = append(ports, serverAddr(server, "853"))
+	}
+	for _, addr := range ports {
+		if times := tcpConnect(addr, source, *baselineCount); len(times) > 0 {
+			_, port, _ := net.SplitHostPort(addr)
+			return networkRTT{"tcp/" + port, medianDuration(times)}, true
+		}
+	}
+	return networkRTT{}, false
+}
+
+// ping sends count ICMP echo requests to ip from source and returns the
+// round trips of those answered. It returns nothing if raw sockets aren't
+// permitted.
+func ping(ip net.IP, source string, count int) Times {
+	network, request, reply := "ip4:icmp", byte(8), byte(0)
+	if ip.To4() == nil {
+		network, request, reply = "ip6:ipv6-icmp", 128, 129
+	}
+	var lc net.ListenConfig
+	local := ""
+	if source != "" {
+		if src := sourceAddr(source, ip.String()); src != nil {
+			local = src.String()
+		}
+		if net.ParseIP(source) == nil {
+			lc.Control = bindToDevice(source)
+		}
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, local)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	id := uint16(os.Getpid())
+	var times Times
+	buf := make([]byte, 1500)
+	for seq := 0; seq < count; seq++ {
+		msg := make([]byte, 16)
+		msg[0] = request
+		binary.BigEndian.PutUint16(msg[4:], id)
+		binary.BigEndian.PutUint16(msg[6:], uint16(seq))
+		if request == 8 {
+			// the kernel fills in the ICMPv6 checksum
+			binary.BigEndian.PutUint16(msg[2:], checksum(msg))
+		}
+		sent := time.Now()
+		if _, err := conn.WriteTo(msg, &net.IPAddr{IP: ip}); err != nil {
+			return times
+		}
+		conn.SetReadDeadline(sent.Add(2 * time.Second))
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			a, ok := from.(*net.IPAddr)
+			if !ok || !a.IP.Equal(ip) || n < 8 || buf[0] != reply ||
+				binary.BigEndian.Uint16(buf[4:]) != id || binary.BigEndian.Uint16(buf[6:]) != uint16(seq) {
+				continue
+			}
+			times = append(times, time.Since(sent))
+			break
+		}
+	}
+	return times
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// tcpConnect times count TCP handshakes with addr from source.
+func tcpConnect(addr, source string, count int) Times {
+	d := dialer("tcp", source, addr, 2*time.Second)
+	var times Times
+	for i := 0; i < count; i++ {
+		start := time.Now()
+		conn, err := d.Dial("tcp", addr)
+		if err != nil {
+			continue
+		}
+		times = append(times, time.Since(start))
+		conn.Close()
+	}
+	return times
+}
+
+func medianDuration(times Times) time.Duration {
+	sorted := append(Times(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// printBaselines splits each server's median first try response time into
+// the network round trip and the time the resolver itself took.
+func printBaselines(report Report, results []Result, baselines map[string]networkRTT) {
+	if len(baselines) == 0 {
+		return
+	}
+	byName := make(map[string][]Result)
+	for _, r := range results {
+		byName[r.name()] = append(byName[r.name()], r)
+	}
+
+	fmt.Println("\nResolver processing time; median DNS response minus network round trip")
+	for _, v := range report {
+		b, ok := baselines[v.label()]
+		first := firstTry(byName[v.label()])
+		if !ok || len(first) == 0 {
+			continue
+		}
+		dns := medianDuration(first)
+		fmt.Printf("%15v dns[%6.1f]ms network[%6.1f]ms %-9v processing[%6.1f]ms\n",
+			v.label(), ms(dns), ms(b.rtt), b.method, ms(dns-b.rtt))
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1e6
+}
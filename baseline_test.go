@@ -0,0 +1,147 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenTCP accepts connections on the loopback for the rest of the test,
+// sending the address of each peer to the returned channel.
+func listenTCP(t *testing.T) (string, chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	peers := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			select {
+			case peers <- host:
+			default:
+			}
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), peers
+}
+
+// closedPort returns a loopback address nothing listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestTCPConnect(t *testing.T) {
+	addr, _ := listenTCP(t)
+	times := tcpConnect(addr, "", 3)
+	if len(times) != 3 {
+		t.Fatalf("timed %d handshakes, want 3", len(times))
+	}
+	for _, d := range times {
+		if d <= 0 || d > time.Second {
+			t.Errorf("loopback handshake took %v", d)
+		}
+	}
+	if times := tcpConnect(closedPort(t), "", 3); len(times) != 0 {
+		t.Errorf("timed %d handshakes with a closed port", len(times))
+	}
+}
+
+func TestTCPConnectFromSource(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only Linux answers on all of 127/8")
+	}
+	addr, peers := listenTCP(t)
+	if times := tcpConnect(addr, "127.0.0.2", 1); len(times) != 1 {
+		t.Fatal("handshake failed")
+	}
+	if peer := <-peers; peer != "127.0.0.2" {
+		t.Errorf("connected from %v, want the source 127.0.0.2", peer)
+	}
+}
+
+func TestMeasureBaseline(t *testing.T) {
+	setFlag(t, "baseline-count", "3")
+	addr, _ := listenTCP(t)
+	_, port, _ := net.SplitHostPort(addr)
+
+	b, ok := measureBaseline(addr, "")
+	if !ok {
+		t.Fatal("no baseline for a listening loopback server")
+	}
+	// ICMP is tried first and works where raw sockets are allowed
+	if b.method != "icmp" && b.method != "tcp/"+port {
+		t.Errorf("measured with %q, want icmp or tcp/%s", b.method, port)
+	}
+	if b.rtt <= 0 || b.rtt > time.Second {
+		t.Errorf("loopback round trip %v", b.rtt)
+	}
+
+	if _, ok := measureBaseline("stub", ""); ok {
+		t.Error("measured a baseline for the OS stub")
+	}
+
+	baselines := measureBaselines([]string{addr, "stub"})
+	if _, ok := baselines[addr]; !ok || len(baselines) != 1 {
+		t.Errorf("got baselines for %v, want only %s", baselineNames(baselines), addr)
+	}
+}
+
+func TestMeasureBaselinesPerSource(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only Linux answers on all of 127/8")
+	}
+	setFlag(t, "baseline-count", "1")
+	setFlag(t, "source-ip", "127.0.0.1,127.0.0.2")
+	addr, _ := listenTCP(t)
+	baselines := measureBaselines([]string{addr})
+	for _, source := range []string{"127.0.0.1", "127.0.0.2"} {
+		if _, ok := baselines[label(addr, source)]; !ok {
+			t.Errorf("no baseline for %s, got %v", label(addr, source), baselineNames(baselines))
+		}
+	}
+	if len(baselines) != 2 {
+		t.Errorf("got baselines for %v, want one per source", baselineNames(baselines))
+	}
+}
+
+func baselineNames(m map[string]networkRTT) string {
+	var k []string
+	for s := range m {
+		k = append(k, s)
+	}
+	return strings.Join(k, ", ")
+}
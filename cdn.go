@@ -0,0 +1,137 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	cdnCheck = flag.Bool("cdn", false, "Connect to the first address each answer gives and rank servers by DNS time plus connect time")
+	cdnPort  = flag.String("cdn-port", "443", "Port connected to on the answered addresses with -cdn")
+)
+
+// connectTimes measures the TCP connect time to every distinct first
+// answer in results, at most -r at a time. Addresses that can't be reached
+// are left out.
+func connectTimes(results []Result) map[string]time.Duration {
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if r.ok && r.first != "" {
+			seen[r.first] = true
+		}
+	}
+
+	times := make(map[string]time.Duration)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan bool, *numOResolvers)
+	for ip := range seen {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			limit <- true
+			t := tcpConnect(net.JoinHostPort(ip, *cdnPort), probeSource(), 3)
+			<-limit
+			if len(t) == 0 {
+				return
+			}
+			mu.Lock()
+			times[ip] = medianDuration(t)
+			mu.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+	return times
+}
+
+// cdnRecord is a server's DNS time plus the time to connect to the edge
+// it sent us to.
+type cdnRecord struct {
+	name        string
+	dns         Times
+	connect     Times
+	total       Times
+	unreachable int
+}
+
+// score is the mean DNS plus connect time in milliseconds, with every
+// answer that couldn't be reached costing -loss-penalty like a lost query,
+// as a client waits about that long before trying another address.
+func (c *cdnRecord) score() float64 {
+	n := len(c.total) + c.unreachable
+	if n == 0 {
+		return math.Inf(1)
+	}
+	rate := float64(c.unreachable) / float64(n)
+	return c.total.mean() + rate*ms(*lossPenalty)
+}
+
+// rankCDN pairs every answer in results with the connect time to its
+// first address and ranks the servers by score.
+func rankCDN(results []Result, connect map[string]time.Duration) []*cdnRecord {
+	byName := make(map[string]*cdnRecord)
+	var records []*cdnRecord
+	for _, r := range results {
+		if !r.ok || r.first == "" {
+			continue
+		}
+		c := byName[r.name()]
+		if c == nil {
+			c = &cdnRecord{name: r.name()}
+			byName[r.name()] = c
+			records = append(records, c)
+		}
+		t, ok := connect[r.first]
+		if !ok {
+			c.unreachable++
+			continue
+		}
+		c.dns = append(c.dns, r.rtt)
+		c.connect = append(c.connect, t)
+		c.total = append(c.total, r.rtt+t)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].score() < records[j].score()
+	})
+	return records
+}
+
+// printCDN ranks servers by the time a client takes to look up a name and
+// connect to the address it's given, so a fast resolver handing out a
+// distant edge ranks below a slower one handing out a near edge.
+func printCDN(results []Result) {
+	records := rankCDN(results, connectTimes(results))
+
+	fmt.Printf("\nOrdered by DNS time plus TCP connect time to the first answer on port %v, plus unreachable x %v\n", *cdnPort, *lossPenalty)
+	for k, c := range records {
+		if len(c.total) == 0 {
+			fmt.Printf("#%2d %15v no answer reachable unreachable[%4d]\n", k+1, c.name, c.unreachable)
+			continue
+		}
+		fmt.Printf("#%2d %15v score[%6.1f] total[%6.1f]ms dns[%6.1f]ms connect[%6.1f]ms n[%4d] unreachable[%4d]\n",
+			k+1, c.name, c.score(), c.total.mean(), c.dns.mean(), c.connect.mean(),
+			len(c.total), c.unreachable)
+	}
+}
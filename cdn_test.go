@@ -0,0 +1,68 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRankCDNPenalisesUnreachable(t *testing.T) {
+	setFlag(t, "loss-penalty", "1s")
+	answer := func(server, first string, rtt time.Duration) Result {
+		return Result{server: server, host: "example.com", ok: true, rtt: rtt, first: first}
+	}
+	connect := map[string]time.Duration{
+		"192.0.2.10": 20 * time.Millisecond,
+		"192.0.2.20": 50 * time.Millisecond,
+	}
+	var results []Result
+	for i := 0; i < 10; i++ {
+		// fastest when reachable, but a tenth of its edges are down
+		first := "192.0.2.10"
+		if i == 0 {
+			first = "192.0.2.99"
+		}
+		results = append(results,
+			answer("flaky", first, 5*time.Millisecond),
+			answer("steady", "192.0.2.20", 10*time.Millisecond),
+			answer("down", "192.0.2.99", time.Millisecond),
+			Result{server: "failed", host: "example.com"})
+	}
+
+	records := rankCDN(results, connect)
+	var order []string
+	for _, c := range records {
+		order = append(order, c.name)
+	}
+	want := []string{"steady", "flaky", "down"}
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+	if s := records[1].score(); s < 124 || s > 126 {
+		t.Errorf("flaky scored %v, want 25ms plus a tenth of a second", s)
+	}
+	if s := records[2].score(); s != 1000 {
+		t.Errorf("unreachable server scored %v, want the full penalty", s)
+	}
+}
@@ -0,0 +1,151 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+)
+
+var quorum = flag.Float64("quorum", 0.1, "Fraction of other servers that must share an answer for it to agree with the consensus")
+
+// disagreement is an answer that no consensus backs up.
+type disagreement struct {
+	host    string
+	answers []string
+}
+
+// agreement counts how often a server's answers agree with the others.
+type agreement struct {
+	compared int
+	agreed   int
+	differ   []disagreement
+}
+
+func (a agreement) rate() float64 {
+	if a.compared == 0 {
+		return math.NaN()
+	}
+	return 100 * float64(a.agreed) / float64(a.compared)
+}
+
+// checkAnswers compares the answers every server gave for the same host.
+// CDNs hand different resolvers different edges, so an answer agrees when
+// it shares an address, or the /24 (/48 for IPv6) around one, with enough
+// of the other servers. Empty answers agree only with other empty answers.
+func checkAnswers(results []Result) map[string]*agreement {
+	byHost := make(map[string][]Result)
+	for _, r := range results {
+		if r.ok {
+			byHost[r.host] = append(byHost[r.host], r)
+		}
+	}
+
+	agreements := make(map[string]*agreement)
+	for host, rs := range byHost {
+		if len(rs) < 2 {
+			continue
+		}
+		for i, r := range rs {
+			support := 0
+			for j, other := range rs {
+				if i != j && overlaps(r.answers, other.answers) {
+					support++
+				}
+			}
+			a := agreements[r.name()]
+			if a == nil {
+				a = new(agreement)
+				agreements[r.name()] = a
+			}
+			a.compared++
+			need := int(math.Ceil(*quorum * float64(len(rs)-1)))
+			if need < 1 {
+				need = 1
+			}
+			if support >= need {
+				a.agreed++
+			} else {
+				a.differ = append(a.differ, disagreement{host, r.answers})
+			}
+		}
+	}
+	return agreements
+}
+
+func overlaps(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	nets := make(map[string]bool)
+	for _, ip := range a {
+		nets[subnet(ip)] = true
+	}
+	for _, ip := range b {
+		if nets[subnet(ip)] {
+			return true
+		}
+	}
+	return false
+}
+
+// subnet returns the /24 or /48 containing ip.
+func subnet(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ip
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return addr.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// printDisagreements lists the answers that differ from the consensus,
+// a few per server.
+func printDisagreements(report Report, agreements map[string]*agreement) {
+	const shown = 3
+	header := false
+	for _, v := range report {
+		a := agreements[v.label()]
+		if a == nil || len(a.differ) == 0 {
+			continue
+		}
+		if !header {
+			fmt.Println("\nAnswers differing from the other servers")
+			header = true
+		}
+		differ := a.differ
+		sort.Slice(differ, func(i, j int) bool { return differ[i].host < differ[j].host })
+		for i, d := range differ {
+			if i == shown {
+				fmt.Printf("%15v ... and %d more\n", v.label(), len(differ)-shown)
+				break
+			}
+			answer := strings.Join(d.answers, " ")
+			if answer == "" {
+				answer = "empty answer"
+			}
+			fmt.Printf("%15v %31v -> %v\n", v.label(), d.host, answer)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import "testing"
+
+func TestCheckAnswers(t *testing.T) {
+	quick(t)
+	honest := []string{
+		startMock(t, &mockResolver{}, "127.0.0.1"),
+		startMock(t, &mockResolver{}, "127.0.0.1"),
+		startMock(t, &mockResolver{}, "127.0.0.1"),
+	}
+	liar := startMock(t, &mockResolver{hijack: 1}, "127.0.0.1")
+	servers := append(append([]string(nil), honest...), liar)
+	results := runQueries(benchmark{hosts: mockHosts(10), servers: servers, protocol: "udp", progress: discard{}})
+
+	agreements := checkAnswers(results)
+	for _, s := range honest {
+		if a := agreements[s]; a == nil || a.compared != 10 || a.rate() != 100 {
+			t.Errorf("%s: agreement %+v, want all 10 answers agreeing", s, a)
+		}
+	}
+	a := agreements[liar]
+	if a == nil || a.compared != 10 || a.rate() != 0 || len(a.differ) != 10 {
+		t.Fatalf("hijacker: agreement %+v, want all 10 answers differing", a)
+	}
+	for _, d := range a.differ {
+		if len(d.answers) != 1 || d.answers[0] != mockHijackIP.String() {
+			t.Errorf("%s: differing answer %v, want the hijack address", d.host, d.answers)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want bool
+	}{
+		{nil, nil, true},
+		{[]string{"192.0.2.1"}, nil, false},
+		{[]string{"192.0.2.1"}, []string{"192.0.2.200"}, true},
+		{[]string{"192.0.2.1"}, []string{"198.51.100.1"}, false},
+		{[]string{"198.51.100.1", "192.0.2.1"}, []string{"192.0.2.9"}, true},
+		{[]string{"2001:db8:1:2::1"}, []string{"2001:db8:1:ff::1"}, true},
+		{[]string{"2001:db8:1::1"}, []string{"2001:db8:2::1"}, false},
+	}
+	for _, tt := range tests {
+		if got := overlaps(tt.a, tt.b); got != tt.want {
+			t.Errorf("overlaps(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	coordinator = flag.String("coordinator", "", "URL of the coordinator an agent streams its results to, like http://host:8053")
+	site        = flag.String("site", "", "Name of the site an agent runs at, the host name if not given")
+)
+
+// streamInterval is how often an agent sends the results gathered since
+// the last batch, and how often the coordinator redraws its matrix.
+const streamInterval = time.Second
+
+// streamTimeout bounds each batch sent to the coordinator.
+const streamTimeout = 10 * time.Second
+
+// finalTries is how many times an agent tries to send the batches still
+// undelivered when a run ends, finalDelay apart, before giving up.
+const finalTries = 5
+
+var finalDelay = 2 * time.Second
+
+// streamer sends every result to the coordinator, in batches of newline
+// separated JSONResults, while passing them on to the progress display.
+// Each batch carries the agent's run ID and a sequence number so the
+// coordinator can drop one it already took.
+type streamer struct {
+	next   observer
+	url    string
+	run    string
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []Result
+	seq     int
+	unsent  []batch // in order, oldest first
+	err     error   // why batches were left undelivered at the end of a run
+	stop    chan bool
+	stopped chan bool
+}
+
+// batch is a numbered set of results sent to the coordinator.
+type batch struct {
+	seq     int
+	results []Result
+}
+
+func newStreamer(next observer) (*streamer, error) {
+	if *coordinator == "" {
+		return nil, fmt.Errorf("-coordinator is needed")
+	}
+	name := *site
+	if name == "" {
+		var err error
+		if name, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	u := strings.TrimSuffix(*coordinator, "/") + "/sites/" + url.PathEscape(name) + "/results"
+	return &streamer{
+		next:   next,
+		url:    u,
+		run:    strconv.FormatInt(time.Now().UnixNano(), 36),
+		client: &http.Client{Timeout: streamTimeout},
+	}, nil
+}
+
+func (s *streamer) start(queries []Query) {
+	s.next.start(queries)
+	s.stop = make(chan bool)
+	s.stopped = make(chan bool)
+	go func() {
+		tick := time.NewTicker(streamInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				s.flush()
+			case <-s.stop:
+				s.finish()
+				close(s.stopped)
+				return
+			}
+		}
+	}()
+}
+
+func (s *streamer) result(r Result) {
+	s.next.result(r)
+	s.mu.Lock()
+	s.pending = append(s.pending, r)
+	s.mu.Unlock()
+}
+
+func (s *streamer) done() {
+	close(s.stop)
+	<-s.stopped
+	s.next.done()
+}
+
+// finish sends what is left at the end of a run, trying a few times
+// before recording why it couldn't.
+func (s *streamer) finish() {
+	var err error
+	for try := 0; try < finalTries; try++ {
+		if try > 0 {
+			time.Sleep(finalDelay)
+		}
+		if err = s.flush(); err == nil {
+			break
+		}
+	}
+	s.mu.Lock()
+	s.err = nil
+	if err != nil {
+		n := 0
+		for _, b := range s.unsent {
+			n += len(b.results)
+		}
+		s.err = fmt.Errorf("%d results not delivered: %v", n, err)
+	}
+	s.mu.Unlock()
+}
+
+// failed returns why results were left undelivered by the last run, if
+// they were.
+func (s *streamer) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// flush numbers the pending results as a new batch and sends every
+// undelivered batch in order. A batch the coordinator doesn't take is
+// kept and sent again, unchanged, with the next.
+func (s *streamer) flush() error {
+	s.mu.Lock()
+	if len(s.pending) > 0 {
+		s.seq++
+		s.unsent = append(s.unsent, batch{s.seq, s.pending})
+		s.pending = nil
+	}
+	unsent := s.unsent
+	s.mu.Unlock()
+
+	for _, b := range unsent {
+		if err := s.send(b); err != nil {
+			fmt.Printf("coordinator: %v, will retry %d results\n", err, len(b.results))
+			return err
+		}
+		s.mu.Lock()
+		s.unsent = s.unsent[1:]
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *streamer) send(b batch) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range b.results {
+		enc.Encode(r.JSON())
+	}
+	u := fmt.Sprintf("%s?run=%s&seq=%d", s.url, s.run, b.seq)
+	resp, err := s.client.Post(u, "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("%v", resp.Status)
+	}
+	return nil
+}
+
+// Limits on what the coordinator takes and keeps.
+const (
+	maxBatchBytes = 16 << 20 // body of one batch
+	maxSites      = 1000
+	keepResults   = 100000 // per site, the oldest are dropped
+	keepBatches   = 100000 // batches remembered to drop resends of
+)
+
+// matrix collects the results every site's agent sent.
+type matrix struct {
+	mu      sync.Mutex
+	sites   map[string][]Result
+	batches map[string]bool // site, run and sequence of the batches taken
+	taken   []string        // batches in the order taken, to forget the oldest
+	changed bool
+}
+
+func newMatrix() *matrix {
+	return &matrix{sites: make(map[string][]Result), batches: make(map[string]bool)}
+}
+
+// coordinate takes results from agents on -listen and keeps a matrix of
+// every resolver's performance from every site:
+//
+//	POST /sites/{site}/results?run={id}&seq={n}
+//	                            newline separated JSONResults from an agent
+//	GET  /matrix                the matrix as JSON
+func coordinate() error {
+	m := newMatrix()
+	go func() {
+		for range time.Tick(streamInterval) {
+			m.mu.Lock()
+			changed := m.changed
+			m.changed = false
+			m.mu.Unlock()
+			if changed {
+				m.print()
+			}
+		}
+	}()
+
+	fmt.Printf("\nCoordinating agents on %v\n", *listen)
+	return http.ListenAndServe(*listen, m.handler())
+}
+
+func (m *matrix) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sites/", m.handleResults)
+	mux.HandleFunc("/matrix", m.handleMatrix)
+	return mux
+}
+
+func (m *matrix) handleResults(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/sites/"), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "results" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+	name := parts[0]
+	run, seq := r.URL.Query().Get("run"), r.URL.Query().Get("seq")
+	if _, err := strconv.Atoi(seq); run == "" || err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("run and seq are needed"))
+		return
+	}
+	key := name + "/" + run + "/" + seq
+
+	// take the whole batch or none of it, so the agent can safely resend
+	var batch []Result
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	for {
+		var j JSONResult
+		err := dec.Decode(&j)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		batch = append(batch, j.result())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.batches[key] {
+		// a resend of a batch whose answer the agent didn't get
+		writeJSON(w, http.StatusAccepted, map[string]int{"accepted": 0})
+		return
+	}
+	if _, ok := m.sites[name]; !ok && len(m.sites) >= maxSites {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("too many sites"))
+		return
+	}
+	m.batches[key] = true
+	m.taken = append(m.taken, key)
+	if len(m.taken) > keepBatches {
+		delete(m.batches, m.taken[0])
+		m.taken = m.taken[1:]
+	}
+	rs := append(m.sites[name], batch...)
+	if len(rs) > keepResults {
+		rs = append([]Result(nil), rs[len(rs)-keepResults:]...)
+	}
+	m.sites[name] = rs
+	m.changed = true
+	writeJSON(w, http.StatusAccepted, map[string]int{"accepted": len(batch)})
+}
+
+// JSONMatrix is every site's report, by site then server.
+type JSONMatrix struct {
+	Sites   []string                         `json:"sites"`
+	Servers []string                         `json:"servers"`
+	Cells   map[string]map[string]JSONRecord `json:"cells"`
+}
+
+// reports ranks the servers as seen from each site.
+func (m *matrix) reports() (sites, servers []string, reports map[string]Report) {
+	m.mu.Lock()
+	results := make(map[string][]Result)
+	for name, rs := range m.sites {
+		results[name] = append([]Result(nil), rs...)
+	}
+	m.mu.Unlock()
+
+	reports = make(map[string]Report)
+	seen := make(map[string]bool)
+	for name, rs := range results {
+		sites = append(sites, name)
+		reports[name], _ = buildReport(rs)
+		for _, r := range rs {
+			if !seen[r.name()] {
+				seen[r.name()] = true
+				servers = append(servers, r.name())
+			}
+		}
+	}
+	sort.Strings(sites)
+	sort.Strings(servers)
+	return sites, servers, reports
+}
+
+func (m *matrix) handleMatrix(w http.ResponseWriter, r *http.Request) {
+	sites, servers, reports := m.reports()
+	out := JSONMatrix{Sites: sites, Servers: servers, Cells: make(map[string]map[string]JSONRecord)}
+	if out.Sites == nil {
+		out.Sites, out.Servers = []string{}, []string{}
+	}
+	for _, name := range sites {
+		out.Cells[name] = make(map[string]JSONRecord)
+		for _, rec := range reports[name].JSON() {
+			out.Cells[name][label(rec.Server, rec.Source)] = rec
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// print shows each resolver's first try response time, loss and rank at
+// every site, a row per resolver and a column per site.
+func (m *matrix) print() {
+	sites, servers, reports := m.reports()
+	cells := make(map[string]map[string]string)
+	for _, name := range sites {
+		cells[name] = make(map[string]string)
+		for k, v := range reports[name] {
+			first := v.first.avg
+			if v.first.n == 0 {
+				first = v.times.avg
+			}
+			cells[name][v.label()] = fmt.Sprintf("%6.1fms %5.1f%% #%-2d", first, 100*v.loss.rate(), k+1)
+		}
+	}
+
+	fmt.Printf("\nFirst try response time, loss and rank by site, %v\n", time.Now().Format("15:04:05"))
+	fmt.Printf("%26v", "")
+	for _, name := range sites {
+		fmt.Printf(" %22v", name)
+	}
+	fmt.Println()
+	for _, server := range servers {
+		fmt.Printf("%26v", server)
+		for _, name := range sites {
+			c, ok := cells[name][server]
+			if !ok {
+				c = "-"
+			}
+			fmt.Printf(" %22v", c)
+		}
+		fmt.Println()
+	}
+}
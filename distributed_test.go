@@ -0,0 +1,187 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgentsStreamToCoordinator(t *testing.T) {
+	quick(t)
+	m := newMatrix()
+	coord := httptest.NewServer(m.handler())
+	defer coord.Close()
+	setFlag(t, "coordinator", coord.URL)
+
+	servers := []string{
+		startMock(t, &mockResolver{latency: 5 * time.Millisecond}, "127.0.0.1"),
+		startMock(t, &mockResolver{latency: 30 * time.Millisecond}, "127.0.0.1"),
+	}
+	sites := []string{"sydney", "london", "denver"}
+	done := make(chan error)
+	for _, name := range sites {
+		setFlag(t, "site", name)
+		s, err := newStreamer(discard{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			runQueries(benchmark{hosts: mockHosts(8), servers: servers, protocol: "udp", progress: s})
+			done <- s.failed()
+		}()
+	}
+	for range sites {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+
+	resp, err := http.Get(coord.URL + "/matrix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out JSONMatrix
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Sites) != len(sites) || len(out.Servers) != len(servers) {
+		t.Fatalf("got sites %v and servers %v, want %d and %d", out.Sites, out.Servers, len(sites), len(servers))
+	}
+	for _, name := range sites {
+		for _, server := range servers {
+			rec, ok := out.Cells[name][server]
+			if !ok || rec.Answered != 8 {
+				t.Errorf("%s saw %s answer %d, want every one of 8 counted once", name, server, rec.Answered)
+			}
+		}
+	}
+}
+
+func TestCoordinatorDropsResentBatches(t *testing.T) {
+	m := newMatrix()
+	coord := httptest.NewServer(m.handler())
+	defer coord.Close()
+
+	r := Result{server: "192.0.2.1", host: "example.com", ok: true, rtt: time.Millisecond}
+	body, _ := json.Marshal(r.JSON())
+	post := func(query string) int {
+		resp, err := http.Post(coord.URL+"/sites/home/results"+query, "application/x-ndjson", strings.NewReader(string(body)+"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, query := range []string{"?run=a&seq=1", "?run=a&seq=1", "?run=a&seq=2", "?run=b&seq=1"} {
+		if code := post(query); code != http.StatusAccepted {
+			t.Errorf("%s: status %d", query, code)
+		}
+	}
+	if code := post(""); code != http.StatusBadRequest {
+		t.Errorf("batch without a sequence: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if n := len(m.sites["home"]); n != 3 {
+		t.Errorf("kept %d results, want 3 with the resend dropped", n)
+	}
+}
+
+func TestCoordinatorLimits(t *testing.T) {
+	m := newMatrix()
+	coord := httptest.NewServer(m.handler())
+	defer coord.Close()
+
+	r := Result{server: "192.0.2.1", host: "example.com", ok: true, rtt: time.Millisecond}
+	line, _ := json.Marshal(r.JSON())
+	post := func(site, query, body string) int {
+		resp, err := http.Post(coord.URL+"/sites/"+site+"/results"+query, "application/x-ndjson", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	huge := strings.Repeat(string(line)+"\n", maxBatchBytes/len(line)+1)
+	if code := post("home", "?run=a&seq=1", huge); code != http.StatusBadRequest {
+		t.Errorf("batch over %d bytes: status %d, want %d", maxBatchBytes, code, http.StatusBadRequest)
+	}
+	if len(m.sites) != 0 {
+		t.Error("kept part of a batch that was too big")
+	}
+
+	// the oldest results and batches are forgotten
+	m.sites["home"] = make([]Result, keepResults)
+	for i := 0; i < keepBatches; i++ {
+		key := "old/a/" + strconv.Itoa(i)
+		m.batches[key] = true
+		m.taken = append(m.taken, key)
+	}
+	if code := post("home", "?run=a&seq=2", string(line)+"\n"); code != http.StatusAccepted {
+		t.Fatalf("status %d", code)
+	}
+	if rs := m.sites["home"]; len(rs) != keepResults || rs[len(rs)-1].server != r.server {
+		t.Errorf("kept %d results, want the newest %d", len(rs), keepResults)
+	}
+	if len(m.batches) != keepBatches || len(m.taken) != keepBatches || m.batches["old/a/0"] || !m.batches["home/a/2"] {
+		t.Errorf("remember %d batches, want the newest %d", len(m.batches), keepBatches)
+	}
+
+	for i := len(m.sites); i < maxSites; i++ {
+		m.sites["site"+strconv.Itoa(i)] = nil
+	}
+	if code := post("another", "?run=a&seq=1", string(line)+"\n"); code != http.StatusServiceUnavailable {
+		t.Errorf("site beyond %d: status %d, want %d", maxSites, code, http.StatusServiceUnavailable)
+	}
+	if code := post("home", "?run=a&seq=3", string(line)+"\n"); code != http.StatusAccepted {
+		t.Errorf("known site: status %d", code)
+	}
+}
+
+func TestStreamerReportsUndelivered(t *testing.T) {
+	finalDelay = time.Millisecond
+	defer func() { finalDelay = 2 * time.Second }()
+	tries := 0
+	coord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tries++
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer coord.Close()
+	setFlag(t, "coordinator", coord.URL)
+	setFlag(t, "site", "home")
+
+	s, err := newStreamer(discard{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.start(nil)
+	s.result(Result{server: "192.0.2.1", host: "example.com", ok: true})
+	s.done()
+	if s.failed() == nil {
+		t.Error("run ended without reporting the undelivered results")
+	}
+	if tries < finalTries {
+		t.Errorf("tried %d times, want at least %d", tries, finalTries)
+	}
+}
@@ -0,0 +1,65 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	dnssecCheck  = flag.Bool("dnssec", false, "Check which servers validate DNSSEC")
+	dnssecSigned = flag.String("dnssec-signed", "ietf.org.", "Name in a correctly signed zone")
+	dnssecBroken = flag.String("dnssec-broken", "dnssec-failed.org.", "Name in a zone with deliberately broken signatures")
+)
+
+// dnssecColumn asks each server for a name in a signed zone and one in a
+// zone with broken signatures, both with the DO bit set. A validating
+// server sets AD on the first and answers SERVFAIL to the second; one that
+// doesn't validate resolves both without AD.
+func dnssecColumn(servers []string) column {
+	return probeAll("dnssec", servers, func(server string) string {
+		signed, _, err := exchange(server, dnssecQuery(*dnssecSigned))
+		if err != nil {
+			return "error"
+		}
+		broken, _, err := exchange(server, dnssecQuery(*dnssecBroken))
+		if err != nil {
+			return "error"
+		}
+
+		resolvesBroken := broken.Rcode == dns.RcodeSuccess && len(addresses(broken)) > 0
+		switch {
+		case signed.Rcode != dns.RcodeSuccess:
+			return "broken"
+		case signed.AuthenticatedData && broken.Rcode == dns.RcodeServerFailure:
+			return "validating"
+		case !signed.AuthenticatedData && resolvesBroken:
+			return "non-validating"
+		}
+		return "broken"
+	})
+}
+
+func dnssecQuery(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	m.SetEdns0(4096, true)
+	return m
+}
@@ -0,0 +1,91 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+)
+
+var family = flag.String("family", "4", "Address family of the servers to benchmark: 4, 6 or both")
+
+func checkFamily(f string) error {
+	switch f {
+	case "4", "6", "both":
+		return nil
+	}
+	return fmt.Errorf("family %q: want 4, 6 or both", f)
+}
+
+// inFamily reports whether server's address is of the -family selected.
+// Servers that aren't addresses are always included.
+func inFamily(server string) bool {
+	host := server
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	v4 := ip.To4() != nil
+	switch *family {
+	case "4":
+		return v4
+	case "6":
+		return !v4
+	}
+	return true
+}
+
+// printDualStack pairs each provider's IPv4 and IPv6 servers, queried from
+// the same source, showing whether the IPv6 path is slower or less
+// reliable.
+func printDualStack(report Report) {
+	records := make(map[string]record)
+	for _, v := range report {
+		records[v.label()] = v
+	}
+
+	type pair struct{ r4, r6 record }
+	var pairs []pair
+	for _, r6 := range report {
+		v4, ok := DualStack[r6.server]
+		if !ok {
+			continue
+		}
+		if r4, ok := records[label(v4, r6.source)]; ok {
+			pairs = append(pairs, pair{r4, r6})
+		}
+	}
+	if len(pairs) == 0 {
+		return
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].r4.label() < pairs[j].r4.label() })
+
+	fmt.Println("\nDual-stack comparison; IPv6 minus IPv4")
+	for _, p := range pairs {
+		r4, r6 := p.r4, p.r6
+		fmt.Printf("%15v avg[%6.1f]ms fail[%5.1f]%%  %26v avg[%6.1f]ms fail[%5.1f]%%  diff[%+7.1f]ms fail[%+6.1f]%%\n",
+			r4.label(), r4.times.avg, r4.failRate(),
+			r6.label(), r6.times.avg, r6.failRate(),
+			r6.times.avg-r4.times.avg, r6.failRate()-r4.failRate())
+	}
+}
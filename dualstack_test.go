@@ -0,0 +1,54 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import "testing"
+
+func TestFamily(t *testing.T) {
+	for _, f := range []string{"4", "6", "both"} {
+		if err := checkFamily(f); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, f := range []string{"", "ipv6", "4,6", "46"} {
+		if checkFamily(f) == nil {
+			t.Errorf("family %q accepted", f)
+		}
+	}
+
+	tests := []struct {
+		family string
+		v4, v6 bool
+	}{
+		{"4", true, false},
+		{"6", false, true},
+		{"both", true, true},
+	}
+	for _, tt := range tests {
+		setFlag(t, "family", tt.family)
+		if got := inFamily("192.0.2.1:53"); got != tt.v4 {
+			t.Errorf("-family %s: IPv4 server included %v", tt.family, got)
+		}
+		if got := inFamily("[2001:db8::1]:53"); got != tt.v6 {
+			t.Errorf("-family %s: IPv6 server included %v", tt.family, got)
+		}
+		if !inFamily("stub") {
+			t.Errorf("-family %s: left out a server that isn't an address", tt.family)
+		}
+	}
+}
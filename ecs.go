@@ -0,0 +1,140 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	ecs      = flag.String("ecs", "", "Attach an EDNS Client Subnet option for this subnet, eg. 203.0.113.0/24, to every query")
+	ecsCheck = flag.Bool("ecs-check", false, "Check whether servers forward, strip or honour EDNS Client Subnet")
+	ecsProbe = flag.String("ecs-probe", "o-o.myaddr.l.google.com.", "TXT name whose answer reports the client subnet the authoritative server saw")
+)
+
+// used by the ECS check when -ecs isn't given
+const defaultECS = "203.0.113.0/24"
+
+// parseECS returns the EDNS Client Subnet option for subnet.
+func parseECS(subnet string) (*dns.EDNS0_SUBNET, error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := ipnet.Mask.Size()
+	o := new(dns.EDNS0_SUBNET)
+	o.Code = dns.EDNS0SUBNET
+	o.SourceNetmask = uint8(ones)
+	o.Address = ipnet.IP
+	o.Family = 1
+	if ipnet.IP.To4() == nil {
+		o.Family = 2
+	}
+	return o, nil
+}
+
+// setECS attaches the -ecs option to m, if one was given.
+func setECS(m *dns.Msg) {
+	if *ecs == "" {
+		return
+	}
+	o, err := parseECS(*ecs)
+	if err != nil {
+		return
+	}
+	addOption(m, o)
+}
+
+// addOption adds an EDNS0 option to m, adding the OPT record if needed.
+func addOption(m *dns.Msg, o dns.EDNS0) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(4096, false)
+		opt = m.IsEdns0()
+	}
+	opt.Option = append(opt.Option, o)
+}
+
+// ecsOf returns the EDNS Client Subnet option in m, if any.
+func ecsOf(m *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// ecsColumn sends each server a query carrying a client subnet for a name
+// whose TXT answer echoes the subnet the authoritative server received. A
+// non zero scope in the response means the server honours the subnet,
+// seeing it upstream means it forwards it, otherwise it is stripped.
+func ecsColumn(servers []string) column {
+	subnet := *ecs
+	if subnet == "" {
+		subnet = defaultECS
+	}
+	o, err := parseECS(subnet)
+	if err != nil {
+		return column{name: "ecs"}
+	}
+	sent := fmt.Sprintf("%v/%d", o.Address, o.SourceNetmask)
+
+	return probeAll("ecs", servers, func(server string) string {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(*ecsProbe), dns.TypeTXT)
+		addOption(m, o)
+		ans, _, err := exchange(server, m)
+		if err != nil {
+			return "error"
+		}
+
+		upstream := ""
+		for _, rr := range ans.Answer {
+			if txt, ok := rr.(*dns.TXT); ok {
+				for _, t := range txt.Txt {
+					if strings.HasPrefix(t, "edns0-client-subnet ") {
+						upstream = strings.TrimPrefix(t, "edns0-client-subnet ")
+					}
+				}
+			}
+		}
+
+		e := ecsOf(ans)
+		switch {
+		case e != nil && e.SourceScope > 0:
+			return fmt.Sprintf("honoured/%d", e.SourceScope)
+		case upstream == sent:
+			return "forwarded"
+		case upstream != "":
+			return "replaced"
+		case e != nil:
+			return "echoed"
+		}
+		return "stripped"
+	})
+}
@@ -0,0 +1,63 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import "testing"
+
+func TestECSColumn(t *testing.T) {
+	quick(t)
+	setFlag(t, "attempts", "1")
+	setFlag(t, "ecs-probe", "whoami.ecs.test.")
+
+	want := make(map[string]string)
+	for mode, outcome := range map[string]string{
+		"honour":  "honoured/24",
+		"forward": "forwarded",
+		"replace": "replaced",
+		"echo":    "echoed",
+		"strip":   "stripped",
+	} {
+		want[startMock(t, &mockResolver{ecs: mode}, "127.0.0.1")] = outcome
+	}
+	want[startMock(t, &mockResolver{drop: 1}, "127.0.0.1")] = "error"
+
+	var servers []string
+	for s := range want {
+		servers = append(servers, s)
+	}
+	c := ecsColumn(servers)
+	if c.name != "ecs" {
+		t.Errorf("column named %q", c.name)
+	}
+	for server, outcome := range want {
+		if got := c.values[server]; got != outcome {
+			t.Errorf("%s: got %q, want %q", server, got, outcome)
+		}
+	}
+
+	// a subnet given with -ecs is the one sent
+	setFlag(t, "ecs", "198.51.100.0/25")
+	for server, outcome := range want {
+		if outcome == "honoured/24" {
+			outcome = "honoured/25"
+		}
+		if got := ecsColumn([]string{server}).values[server]; got != outcome {
+			t.Errorf("%s with -ecs: got %q, want %q", server, got, outcome)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+var filterList = flag.String("filter", "", "File of \"category domain\" lines to test each server's content filtering with")
+
+// testDomain is a domain expected to be blocked by servers filtering its
+// category.
+type testDomain struct {
+	category string
+	name     string
+}
+
+func loadFilterList(file string) ([]testDomain, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var domains []testDomain
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"category domain\"", file, line)
+		}
+		domains = append(domains, testDomain{fields[0], dns.Fqdn(strings.ToLower(fields[1]))})
+	}
+	return domains, scanner.Err()
+}
+
+// Ways a server can answer a test domain.
+const (
+	filterAllowed   = "allowed"
+	filterNXDomain  = "nxdomain"  // NXDOMAIN where the others resolve it
+	filterNull      = "null"      // 0.0.0.0 or ::
+	filterSinkhole  = "sinkhole"  // a loopback or private address
+	filterBlockPage = "blockpage" // a public address the others don't give
+	filterError     = "error"
+)
+
+type filterAnswer struct {
+	rcode   int
+	answers []string
+}
+
+// filterProfile maps server, category and method to the number of test
+// domains answered that way.
+type filterProfile map[string]map[string]map[string]int
+
+// checkFiltering looks up every test domain on every server and works out
+// how, if at all, each server blocks it.
+func checkFiltering(servers []string, domains []testDomain) filterProfile {
+	answers := make(map[string]map[string]*filterAnswer)
+	var mu sync.Mutex
+	for _, d := range domains {
+		answers[d.name] = make(map[string]*filterAnswer)
+	}
+
+	var wg sync.WaitGroup
+	limit := make(chan bool, *numOResolvers)
+	for _, d := range domains {
+		for _, s := range servers {
+			wg.Add(1)
+			go func(name, server string) {
+				defer wg.Done()
+				limit <- true
+				defer func() { <-limit }()
+				m := new(dns.Msg)
+				m.SetQuestion(name, dns.TypeA)
+				ans, _, err := exchange(server, m)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				answers[name][server] = &filterAnswer{ans.Rcode, addresses(ans)}
+				mu.Unlock()
+			}(d.name, s)
+		}
+	}
+	wg.Wait()
+
+	profile := make(filterProfile)
+	for _, s := range servers {
+		profile[s] = make(map[string]map[string]int)
+	}
+	for _, d := range domains {
+		for _, s := range servers {
+			method := classifyFilter(s, answers[d.name])
+			if profile[s][d.category] == nil {
+				profile[s][d.category] = make(map[string]int)
+			}
+			profile[s][d.category][method]++
+		}
+	}
+	return profile
+}
+
+// classifyFilter compares the answer server gave with the ones from the
+// other servers.
+func classifyFilter(server string, answers map[string]*filterAnswer) string {
+	a := answers[server]
+	if a == nil {
+		return filterError
+	}
+
+	others, nx, support := 0, 0, 0
+	for s, o := range answers {
+		if s == server {
+			continue
+		}
+		others++
+		if o.rcode == dns.RcodeNameError {
+			nx++
+		}
+		if len(a.answers) > 0 && overlaps(a.answers, o.answers) {
+			support++
+		}
+	}
+
+	if a.rcode == dns.RcodeNameError || len(a.answers) == 0 {
+		// blocked only if most of the others do resolve it
+		if others-nx > others/2 {
+			return filterNXDomain
+		}
+		return filterAllowed
+	}
+	for _, ip := range a.answers {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			continue
+		}
+		if addr.IsUnspecified() {
+			return filterNull
+		}
+		if addr.IsLoopback() || addr.IsPrivate() {
+			return filterSinkhole
+		}
+	}
+	need := int(math.Ceil(*quorum * float64(others)))
+	if need < 1 {
+		need = 1
+	}
+	if others > 0 && support < need {
+		return filterBlockPage
+	}
+	return filterAllowed
+}
+
+// printFilterProfile shows for every server and category how many test
+// domains were blocked, and how.
+func printFilterProfile(servers []string, domains []testDomain, profile filterProfile) {
+	var categories []string
+	total := make(map[string]int)
+	for _, d := range domains {
+		if total[d.category] == 0 {
+			categories = append(categories, d.category)
+		}
+		total[d.category]++
+	}
+	sort.Strings(categories)
+
+	fmt.Println("\nFiltering profile; blocked/tested and how")
+	fmt.Printf("%15v", "")
+	for _, c := range categories {
+		fmt.Printf(" %-26v", c)
+	}
+	fmt.Println()
+	for _, s := range servers {
+		fmt.Printf("%15v", s)
+		for _, c := range categories {
+			blocked := 0
+			var methods []string
+			for method, n := range profile[s][c] {
+				if method == filterAllowed || method == filterError {
+					continue
+				}
+				blocked += n
+				methods = append(methods, method)
+			}
+			sort.Strings(methods)
+			cell := fmt.Sprintf("%d/%d %s", blocked, total[c], strings.Join(methods, "+"))
+			if n := profile[s][c][filterError]; n > 0 {
+				cell += fmt.Sprintf(" (%d errors)", n)
+			}
+			fmt.Printf(" %-26v", cell)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,94 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCheckFiltering(t *testing.T) {
+	quick(t)
+	setFlag(t, "attempts", "1")
+	blocked := map[string]bool{"ads.blocked.test.": true, "malware.blocked.test.": true}
+	modes := []string{"none", "none", "none", "null", "sinkhole", "blockpage", "nxdomain"}
+	servers := make(map[string]string)
+	var list []string
+	for _, mode := range modes {
+		s := startMock(t, &mockResolver{block: mode, blocked: blocked}, "127.0.0.1")
+		servers[s] = mode
+		list = append(list, s)
+	}
+	domains := []testDomain{
+		{"ads", "ads.blocked.test."},
+		{"malware", "malware.blocked.test."},
+		{"control", dns.Fqdn(Top[0])},
+		{"control", "missing.test."}, // NXDOMAIN everywhere
+	}
+	want := map[string]string{
+		"none":      filterAllowed,
+		"null":      filterNull,
+		"sinkhole":  filterSinkhole,
+		"blockpage": filterBlockPage,
+		"nxdomain":  filterNXDomain,
+	}
+
+	profile := checkFiltering(list, domains)
+	for s, mode := range servers {
+		for _, category := range []string{"ads", "malware"} {
+			if got := profile[s][category]; len(got) != 1 || got[want[mode]] != 1 {
+				t.Errorf("%s server: %s profile %v, want 1 %s", mode, category, got, want[mode])
+			}
+		}
+		if got := profile[s]["control"]; len(got) != 1 || got[filterAllowed] != 2 {
+			t.Errorf("%s server: control profile %v, want both allowed", mode, got)
+		}
+	}
+}
+
+func TestClassifyFilterMajority(t *testing.T) {
+	resolved := &filterAnswer{dns.RcodeSuccess, []string{"198.18.0.1"}}
+	nx := &filterAnswer{dns.RcodeNameError, nil}
+	tests := []struct {
+		resolving, nx int
+		want          string
+	}{
+		{4, 0, filterNXDomain},
+		{3, 1, filterNXDomain},
+		{2, 2, filterAllowed}, // half isn't most
+		{1, 3, filterAllowed},
+		{0, 4, filterAllowed},
+		{0, 0, filterAllowed},
+	}
+	for _, tt := range tests {
+		answers := map[string]*filterAnswer{"server": nx}
+		for i := 0; i < tt.resolving; i++ {
+			answers[string(rune('a'+i))] = resolved
+		}
+		for i := 0; i < tt.nx; i++ {
+			answers[string(rune('m'+i))] = nx
+		}
+		if got := classifyFilter("server", answers); got != tt.want {
+			t.Errorf("NXDOMAIN with %d others resolving and %d not: %s, want %s", tt.resolving, tt.nx, got, tt.want)
+		}
+	}
+	if got := classifyFilter("silent", map[string]*filterAnswer{"a": resolved}); got != filterError {
+		t.Errorf("no answer: %s, want %s", got, filterError)
+	}
+}
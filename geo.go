@@ -0,0 +1,286 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	geoipFiles = flag.String("geoip", "", "Comma separated MaxMind DB files, such as GeoLite2-Country.mmdb and GeoLite2-ASN.mmdb, to annotate servers and answers with")
+	asnCSV     = flag.String("asn-csv", "", "CSV file of network,asn,organisation[,country] rows to annotate servers and answers with")
+	country    = flag.String("country", "", "ISO country code of where the benchmark runs, answers located there count as sensible")
+)
+
+// geo is where an address is and which network announces it.
+type geo struct {
+	country string
+	asn     uint64
+	org     string
+}
+
+func (g geo) String() string {
+	c, as := g.country, "AS?"
+	if c == "" {
+		c = "??"
+	}
+	if g.asn != 0 {
+		as = fmt.Sprintf("AS%d", g.asn)
+	}
+	if g.org != "" {
+		as += " " + g.org
+	}
+	return c + " " + as
+}
+
+// asnTable maps networks to their ASN, longest prefix first.
+type asnTable struct {
+	lengths []int
+	nets    map[int]map[string]geo
+}
+
+// geoSources are the databases loaded with -geoip and -asn-csv.
+var geoSources struct {
+	dbs []*mmdb
+	asn *asnTable
+}
+
+// geoEnabled reports whether any database was given.
+func geoEnabled() bool {
+	return len(geoSources.dbs) > 0 || geoSources.asn != nil
+}
+
+func loadGeo() error {
+	for _, file := range strings.Split(*geoipFiles, ",") {
+		if file = strings.TrimSpace(file); file == "" {
+			continue
+		}
+		db, err := openMMDB(file)
+		if err != nil {
+			return err
+		}
+		geoSources.dbs = append(geoSources.dbs, db)
+	}
+	if *asnCSV != "" {
+		t, err := loadASNTable(*asnCSV)
+		if err != nil {
+			return err
+		}
+		geoSources.asn = t
+	}
+	return nil
+}
+
+// loadASNTable reads rows of network,asn,organisation with an optional
+// country, as in the GeoLite2 ASN CSV. Rows not starting with a network,
+// like the header, are skipped.
+func loadASNTable(file string) (*asnTable, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &asnTable{nets: make(map[int]map[string]geo)}
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if len(row) < 2 {
+			continue
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(row[0]))
+		if err != nil {
+			continue
+		}
+		var g geo
+		g.asn, _ = strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(row[1]), "AS"), 10, 32)
+		if len(row) > 2 {
+			g.org = strings.TrimSpace(row[2])
+		}
+		if len(row) > 3 {
+			g.country = strings.ToUpper(strings.TrimSpace(row[3]))
+		}
+		ones, bits := network.Mask.Size()
+		if bits == 32 {
+			ones += 96
+		}
+		if t.nets[ones] == nil {
+			t.nets[ones] = make(map[string]geo)
+			t.lengths = append(t.lengths, ones)
+		}
+		t.nets[ones][network.IP.String()] = g
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(t.lengths)))
+	return t, nil
+}
+
+func (t *asnTable) lookup(ip net.IP) (geo, bool) {
+	for _, ones := range t.lengths {
+		bits := 128
+		if ip.To4() != nil {
+			if ones < 96 {
+				continue
+			}
+			ones, bits = ones-96, 32
+		}
+		key := ip.Mask(net.CIDRMask(ones, bits)).String()
+		if ip.To4() != nil {
+			ones += 96
+		}
+		if g, ok := t.nets[ones][key]; ok {
+			return g, true
+		}
+	}
+	return geo{}, false
+}
+
+// locate looks address up in every database, the first to know a field
+// wins. Servers with a port are looked up by their address.
+func locate(address string) (geo, bool) {
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		address = h
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return geo{}, false
+	}
+
+	var g geo
+	found := false
+	for _, db := range geoSources.dbs {
+		rec, err := db.lookup(ip)
+		if err != nil || rec == nil {
+			continue
+		}
+		found = true
+		if g.country == "" {
+			g.country = isoCode(rec, "country")
+		}
+		if g.country == "" {
+			g.country = isoCode(rec, "registered_country")
+		}
+		if n, ok := rec["autonomous_system_number"].(uint64); ok && g.asn == 0 {
+			g.asn = n
+		}
+		if o, ok := rec["autonomous_system_organization"].(string); ok && g.org == "" {
+			g.org = o
+		}
+	}
+	if geoSources.asn != nil {
+		if a, ok := geoSources.asn.lookup(ip); ok {
+			found = true
+			if g.asn == 0 {
+				g.asn, g.org = a.asn, a.org
+			}
+			if g.country == "" {
+				g.country = a.country
+			}
+		}
+	}
+	return g, found
+}
+
+func isoCode(rec map[string]interface{}, key string) string {
+	c, _ := rec[key].(map[string]interface{})
+	code, _ := c["iso_code"].(string)
+	return code
+}
+
+// printGeo shows the network each server lives in and where the addresses
+// it answered with are. With -country, answers located in that country
+// count as sensible for this location.
+func printGeo(report Report, results []Result) {
+	if !geoEnabled() {
+		return
+	}
+	located := make(map[string]map[string]int)
+	sensible := make(map[string]int)
+	total := make(map[string]int)
+	for _, r := range results {
+		for _, a := range r.answers {
+			g, ok := locate(a)
+			if !ok {
+				continue
+			}
+			if located[r.name()] == nil {
+				located[r.name()] = make(map[string]int)
+			}
+			located[r.name()][g.String()]++
+			total[r.name()]++
+			if strings.EqualFold(g.country, *country) {
+				sensible[r.name()]++
+			}
+		}
+	}
+
+	fmt.Println("\nServer networks and answer locations")
+	for _, v := range report {
+		where := "-"
+		if g, ok := locate(v.server); ok {
+			where = g.String()
+		}
+		fmt.Printf("%15v %-36v", v.label(), where)
+		if *country != "" && total[v.label()] > 0 {
+			fmt.Printf(" in %v[%5.1f]%%", strings.ToUpper(*country), 100*float64(sensible[v.label()])/float64(total[v.label()]))
+		}
+		fmt.Printf(" answers[%v]\n", topLocations(located[v.label()], 3))
+	}
+}
+
+// topLocations lists the n most common answer locations with their share.
+func topLocations(counts map[string]int, n int) string {
+	var names []string
+	total := 0
+	for name, c := range counts {
+		names = append(names, name)
+		total += c
+	}
+	if total == 0 {
+		return "-"
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	var parts []string
+	for i, name := range names {
+		if i == n {
+			parts = append(parts, "...")
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%v %.0f%%", name, 100*float64(counts[name])/float64(total)))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,237 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	format = flag.String("format", "text", "Report format, text or html. The text report is always printed, html is also written to -output")
+	output = flag.String("output", "CloudDNSBenchmark.html", "File the html report is written to")
+)
+
+// runInfo describes a benchmark run for the report's metadata.
+type runInfo struct {
+	started  time.Time
+	finished time.Time
+}
+
+type htmlRow struct {
+	Rank      int
+	Server    string
+	Colour    string
+	Tier      int
+	Score     string
+	First     string
+	Avg       string
+	Min       string
+	Max       string
+	Jitter    string
+	Loss      string
+	N         int
+	Errors    int
+	Agreement string
+}
+
+type htmlErrors struct {
+	Server   string
+	Attempts int
+	Failed   int
+	Counts   []int
+}
+
+type htmlReport struct {
+	Meta     [][2]string
+	Rows     []htmlRow
+	BoxPlot  template.HTML
+	CDF      template.HTML
+	Outcomes []string
+	Errors   []htmlErrors
+}
+
+// writeHTML writes report as a single html file with the charts drawn in
+// inline SVG, so it can be mailed around without any other files.
+func writeHTML(file string, report Report, results []Result, run runInfo) error {
+	var page htmlReport
+	page.Meta = runMeta(results, run)
+
+	s := newSeries(report)
+	for k, v := range report {
+		page.Rows = append(page.Rows, htmlRow{
+			Rank:      k + 1,
+			Server:    v.label(),
+			Colour:    s.colours[v.label()],
+			Tier:      v.tier,
+			Score:     cell("%.1f", v.score()),
+			First:     cell("%.1f", v.first.avg),
+			Avg:       cell("%.1f", v.times.avg),
+			Min:       cell("%.1f", v.times.min),
+			Max:       cell("%.1f", v.times.max),
+			Jitter:    cell("%.1f", v.times.std),
+			Loss:      fmt.Sprintf("%.1f%% (%.1f-%.1f)", 100*v.loss.rate(), 100*v.loss.lo, 100*v.loss.hi),
+			N:         len(v.samples),
+			Errors:    v.errors,
+			Agreement: cell("%.1f%%", v.agreement),
+		})
+	}
+
+	box := new(svgCanvas)
+	page.BoxPlot = template.HTML(box.document(boxPanel(box, 10, s)))
+	cdf := new(svgCanvas)
+	page.CDF = template.HTML(cdf.document(cdfPanel(cdf, 10, s)))
+	page.Outcomes, page.Errors = errorBreakdown(report, results)
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := htmlTemplate.Execute(f, page); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// cell formats v for the ranking table, or a dash for the statistics of a
+// server with no answers.
+func cell(format string, v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "-"
+	}
+	return fmt.Sprintf(format, v)
+}
+
+func runMeta(results []Result, run runInfo) [][2]string {
+	hostname, _ := os.Hostname()
+	hosts := make(map[string]bool)
+	for _, r := range results {
+		hosts[r.host] = true
+	}
+	var set []string
+	flag.Visit(func(f *flag.Flag) {
+		set = append(set, "-"+f.Name+"="+f.Value.String())
+	})
+	options := strings.Join(set, " ")
+	if options == "" {
+		options = "defaults"
+	}
+	return [][2]string{
+		{"Version", "CloudDNSBenchmark 0.0.3"},
+		{"Started", run.started.Format(time.RFC1123)},
+		{"Duration", run.finished.Sub(run.started).Round(time.Second).String()},
+		{"Host", fmt.Sprintf("%v (%v/%v)", hostname, runtime.GOOS, runtime.GOARCH)},
+		{"Queries", fmt.Sprintf("%d to %d distinct names", len(results), len(hosts))},
+		{"Options", options},
+	}
+}
+
+// errorBreakdown counts every server's failed attempts by outcome.
+func errorBreakdown(report Report, results []Result) ([]string, []htmlErrors) {
+	counts := make(map[string]map[string]int)
+	attempts := make(map[string]int)
+	failed := make(map[string]int)
+	outcomes := make(map[string]bool)
+	var names []string
+	for _, v := range report {
+		names = append(names, v.label())
+		counts[v.label()] = make(map[string]int)
+	}
+	for _, r := range results {
+		if counts[r.name()] == nil {
+			continue
+		}
+		for _, a := range r.attempts {
+			attempts[r.name()]++
+			if a.outcome != attemptOK {
+				counts[r.name()][a.outcome]++
+				outcomes[a.outcome] = true
+			}
+		}
+		if !r.ok {
+			failed[r.name()]++
+		}
+	}
+
+	var columns []string
+	for o := range outcomes {
+		columns = append(columns, o)
+	}
+	sort.Strings(columns)
+	var rows []htmlErrors
+	for _, name := range names {
+		row := htmlErrors{Server: name, Attempts: attempts[name], Failed: failed[name]}
+		for _, o := range columns {
+			row.Counts = append(row.Counts, counts[name][o])
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CloudDNSBenchmark report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: right; }
+th { background: #f4f4f4; }
+td.name, th.name { text-align: left; }
+.swatch { display: inline-block; width: 10px; height: 10px; margin-right: 6px; }
+</style>
+</head>
+<body>
+<h1>CloudDNSBenchmark report</h1>
+
+<h2>Run</h2>
+<table>
+{{range .Meta}}<tr><th class="name">{{index . 0}}</th><td class="name">{{index . 1}}</td></tr>
+{{end}}</table>
+
+<h2>Ranking</h2>
+<p>Ordered by first try response time plus a penalty for lost queries. Servers in the same tier are not significantly different. Times are in milliseconds.</p>
+<table>
+<tr><th>#</th><th class="name">Server</th><th>Tier</th><th>Score</th><th>First try</th><th>Average</th><th>Min</th><th>Max</th><th>Jitter</th><th>Loss</th><th>n</th><th>Errors</th><th>Agree</th></tr>
+{{range .Rows}}<tr><td>{{.Rank}}</td><td class="name"><span class="swatch" style="background: {{.Colour}}"></span>{{.Server}}</td><td>{{.Tier}}</td><td>{{.Score}}</td><td>{{.First}}</td><td>{{.Avg}}</td><td>{{.Min}}</td><td>{{.Max}}</td><td>{{.Jitter}}</td><td>{{.Loss}}</td><td>{{.N}}</td><td>{{.Errors}}</td><td>{{.Agreement}}</td></tr>
+{{end}}</table>
+
+<h2>Response times</h2>
+{{.BoxPlot}}
+
+<h2>Cumulative distribution</h2>
+{{.CDF}}
+
+<h2>Errors</h2>
+<table>
+<tr><th class="name">Server</th><th>Attempts</th>{{range .Outcomes}}<th>{{.}}</th>{{end}}<th>Failed queries</th></tr>
+{{range .Errors}}<tr><td class="name">{{.Server}}</td><td>{{.Attempts}}</td>{{range .Counts}}<td>{{.}}</td>{{end}}<td>{{.Failed}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,149 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var identify = flag.Bool("identify", false, "Identify the anycast instance answering each query with NSID, or CHAOS id.server and hostname.bind")
+
+// requestNSID asks the server to include its name server identifier.
+func requestNSID(m *dns.Msg) {
+	addOption(m, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+}
+
+// nsidOf returns the name server identifier in m, decoded to text when it
+// is printable.
+func nsidOf(m *dns.Msg) string {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	for _, o := range opt.Option {
+		n, ok := o.(*dns.EDNS0_NSID)
+		if !ok || n.Nsid == "" {
+			continue
+		}
+		b, err := hex.DecodeString(n.Nsid)
+		if err != nil {
+			return n.Nsid
+		}
+		for _, c := range b {
+			if c < 0x20 || c > 0x7e {
+				return n.Nsid
+			}
+		}
+		return string(b)
+	}
+	return ""
+}
+
+// How long to wait for a CHAOS answer. It's asked for while the query
+// still holds its server's slot, so it gets one short try.
+const chaosTimeout = time.Second
+
+// servers and sources that gave no answer to a CHAOS query, so aren't
+// asked again
+var noChaos = struct {
+	sync.Mutex
+	queries map[chaosKey]bool
+}{queries: make(map[chaosKey]bool)}
+
+type chaosKey struct{ server, source string }
+
+// chaosIdentity asks the server of query, as query would, for its
+// identity with the CHAOS class TXT queries id.server and, failing that,
+// hostname.bind.
+func chaosIdentity(query Query) string {
+	key := chaosKey{query.server, query.source}
+	noChaos.Lock()
+	skip := noChaos.queries[key]
+	noChaos.Unlock()
+	if skip {
+		return ""
+	}
+
+	c := queryClient(query.protocol, query)
+	if *timeout > chaosTimeout {
+		c.DialTimeout, c.ReadTimeout = chaosTimeout, chaosTimeout
+		if query.source != "" {
+			c.Dialer = dialer(c.Net, query.source, query.server, chaosTimeout)
+		}
+	}
+	addr := serverAddr(query.server, serverPort(query.protocol))
+	for _, name := range []string{"id.server.", "hostname.bind."} {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeTXT)
+		m.Question[0].Qclass = dns.ClassCHAOS
+		ans, _, err := c.Exchange(m, addr)
+		if err != nil || ans.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		for _, rr := range ans.Answer {
+			if txt, ok := rr.(*dns.TXT); ok && len(txt.Txt) > 0 {
+				return strings.Join(txt.Txt, " ")
+			}
+		}
+	}
+
+	noChaos.Lock()
+	noChaos.queries[key] = true
+	noChaos.Unlock()
+	return ""
+}
+
+// printInstances groups each server's response times by the instance that
+// answered, so anycast routing changes during a run stand out.
+func printInstances(report Report, results []Result) {
+	byInstance := make(map[string]map[string]Times)
+	for _, r := range results {
+		if !r.ok || r.instance == "" {
+			continue
+		}
+		if byInstance[r.name()] == nil {
+			byInstance[r.name()] = make(map[string]Times)
+		}
+		byInstance[r.name()][r.instance] = append(byInstance[r.name()][r.instance], r.rtt)
+	}
+	if len(byInstance) == 0 {
+		return
+	}
+
+	fmt.Println("\nResponse times by answering instance")
+	for _, v := range report {
+		instances := byInstance[v.label()]
+		var names []string
+		for name := range instances {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			times := instances[name]
+			fmt.Printf("%15v %-30v %vn[%4d]\n", v.label(), name, calcRoundTrip(times), len(times))
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChaosIdentity(t *testing.T) {
+	setFlag(t, "timeout", "10s")
+	setFlag(t, "attempts", "3")
+	anycast := startMock(t, &mockResolver{name: "anycast", instances: 3}, "127.0.0.1")
+	seen := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		id := chaosIdentity(Query{server: anycast, protocol: "udp"})
+		if !strings.HasPrefix(id, "anycast.") {
+			t.Fatalf("identity %q, want one of the anycast instances", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("saw instances %v, want the answering one each time", seen)
+	}
+
+	// a server that drops CHAOS queries gets one short try, from the
+	// query's source, and isn't asked again
+	silent := startMock(t, &mockResolver{nochaos: true}, "127.0.0.1")
+	query := Query{server: silent, source: "127.0.0.1", protocol: "udp"}
+	start := time.Now()
+	if id := chaosIdentity(query); id != "" {
+		t.Errorf("identity %q from a server that drops CHAOS queries", id)
+	}
+	if took := time.Since(start); took > 2*chaosTimeout+time.Second {
+		t.Errorf("took %v to give up, want one try of %v for each name", took, chaosTimeout)
+	}
+	if !noChaos.queries[chaosKey{silent, "127.0.0.1"}] || noChaos.queries[chaosKey{silent, ""}] {
+		t.Errorf("failures %v, want the server remembered for its source only", noChaos.queries)
+	}
+	start = time.Now()
+	chaosIdentity(query)
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Errorf("asked again, taking %v", took)
+	}
+}
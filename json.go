@@ -0,0 +1,154 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// JSONResult is a Result as the HTTP API sends it. Times are in
+// milliseconds.
+type JSONResult struct {
+	Server    string        `json:"server"`
+	Source    string        `json:"source,omitempty"`
+	Host      string        `json:"host"`
+	At        time.Time     `json:"at"`
+	OK        bool          `json:"ok"`
+	RTT       float64       `json:"rtt_ms"`
+	Effective float64       `json:"effective_ms"`
+	Rcode     int           `json:"rcode"`
+	Errors    int           `json:"errors"`
+	Answers   []string      `json:"answers,omitempty"`
+	First     string        `json:"first,omitempty"`
+	Instance  string        `json:"instance,omitempty"`
+	Attempts  []JSONAttempt `json:"attempts"`
+}
+
+// JSONAttempt is one try at a query.
+type JSONAttempt struct {
+	Outcome string  `json:"outcome"`
+	RTT     float64 `json:"rtt_ms"`
+}
+
+// JSONRecord is a server's line of the report.
+type JSONRecord struct {
+	Rank      int      `json:"rank"`
+	Server    string   `json:"server"`
+	Source    string   `json:"source,omitempty"`
+	Tier      int      `json:"tier"`
+	Score     *float64 `json:"score,omitempty"` // absent for a server that never answered
+	Min       *float64 `json:"min_ms,omitempty"`
+	Max       *float64 `json:"max_ms,omitempty"`
+	Avg       *float64 `json:"avg_ms,omitempty"`
+	Jitter    *float64 `json:"jitter_ms,omitempty"`
+	First     *float64 `json:"first_ms,omitempty"` // absent if no lookup was answered first time
+	Effective *float64 `json:"effective_ms,omitempty"`
+	Loss      float64  `json:"loss"`
+	LossLo    float64  `json:"loss_lo"`
+	LossHi    float64  `json:"loss_hi"`
+	Answered  int      `json:"answered"`
+	Errors    int      `json:"errors"`
+	Agreement *float64 `json:"agreement,omitempty"` // percent, absent with nothing to compare
+}
+
+// finite returns v, or nil for statistics of no samples, which come out
+// NaN or infinite and can't be encoded.
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// JSON returns r in the form the HTTP API sends.
+func (r Result) JSON() JSONResult {
+	j := JSONResult{
+		Server:    r.server,
+		Source:    r.source,
+		Host:      r.host,
+		At:        r.at,
+		OK:        r.ok,
+		RTT:       ms(r.rtt),
+		Effective: ms(r.effective),
+		Rcode:     r.rcode,
+		Errors:    r.errors,
+		Answers:   r.answers,
+		First:     r.first,
+		Instance:  r.instance,
+		Attempts:  []JSONAttempt{},
+	}
+	for _, a := range r.attempts {
+		j.Attempts = append(j.Attempts, JSONAttempt{a.outcome, ms(a.rtt)})
+	}
+	return j
+}
+
+// JSON returns the report in the form the HTTP API sends.
+func (r Report) JSON() []JSONRecord {
+	records := []JSONRecord{}
+	for k, v := range r {
+		j := JSONRecord{
+			Rank:      k + 1,
+			Server:    v.server,
+			Source:    v.source,
+			Tier:      v.tier,
+			Score:     finite(v.score()),
+			Min:       finite(v.times.min),
+			Max:       finite(v.times.max),
+			Avg:       finite(v.times.avg),
+			Jitter:    finite(v.times.std),
+			First:     finite(v.first.avg),
+			Effective: finite(v.effective.avg),
+			Loss:      v.loss.rate(),
+			LossLo:    v.loss.lo,
+			LossHi:    v.loss.hi,
+			Answered:  len(v.samples),
+			Errors:    v.errors,
+			Agreement: finite(v.agreement),
+		}
+		records = append(records, j)
+	}
+	return records
+}
+
+func duration(ms float64) time.Duration {
+	return time.Duration(ms * 1e6)
+}
+
+// result turns j back into a Result, as the coordinator receives it.
+func (j JSONResult) result() Result {
+	r := Result{
+		server:    j.Server,
+		source:    j.Source,
+		host:      j.Host,
+		at:        j.At,
+		ok:        j.OK,
+		rtt:       duration(j.RTT),
+		effective: duration(j.Effective),
+		rcode:     j.Rcode,
+		errors:    j.Errors,
+		answers:   j.Answers,
+		first:     j.First,
+		instance:  j.Instance,
+	}
+	for _, a := range j.Attempts {
+		r.attempts = append(r.attempts, attempt{a.Outcome, duration(a.RTT)})
+	}
+	return r
+}
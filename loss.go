@@ -0,0 +1,73 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+)
+
+var lossPenalty = flag.Duration("loss-penalty", time.Second, "What a lost query costs in the composite score, roughly a stub resolver's retry timeout")
+
+// loss is the share of attempts at queries that went unanswered.
+type loss struct {
+	lost, sent int
+	lo, hi     float64 // 95% confidence interval
+}
+
+func (l loss) rate() float64 {
+	if l.sent == 0 {
+		return 0
+	}
+	return float64(l.lost) / float64(l.sent)
+}
+
+func (l loss) String() string {
+	return fmt.Sprintf("loss[%5.1f%% %4.1f-%4.1f]", 100*l.rate(), 100*l.lo, 100*l.hi)
+}
+
+// lossOf counts the attempts in results that timed out.
+func lossOf(results []Result) loss {
+	var l loss
+	for _, r := range results {
+		for _, a := range r.attempts {
+			l.sent++
+			if a.outcome == attemptTimeout {
+				l.lost++
+			}
+		}
+	}
+	l.lo, l.hi = wilson(l.lost, l.sent)
+	return l
+}
+
+// score is the composite the report ranks by: the first try response time
+// plus the expected cost of losing the query. A server that never
+// answered ranks last.
+func (r record) score() float64 {
+	if len(r.samples) == 0 {
+		return math.Inf(1)
+	}
+	latency := r.first.avg
+	if r.first.n == 0 {
+		latency = r.times.avg
+	}
+	return latency + r.loss.rate()*float64(lossPenalty.Nanoseconds())/1e6
+}
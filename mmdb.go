@@ -0,0 +1,275 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdb is a MaxMind DB, as used by the GeoLite2 country and ASN databases,
+// read into memory. Only what's needed to look up an address is decoded.
+type mmdb struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	treeSize   uint
+	data       []byte
+}
+
+var mmdbMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+func openMMDB(file string) (*mmdb, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, mmdbMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s: not a MaxMind DB", file)
+	}
+	v, _, err := decodeMMDB(buf[i+len(mmdbMarker):], 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: metadata: %v", file, err)
+	}
+	meta, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: metadata is not a map", file)
+	}
+	db := &mmdb{buf: buf}
+	db.nodeCount = uint(metaUint(meta, "node_count"))
+	db.recordSize = uint(metaUint(meta, "record_size"))
+	db.ipVersion = uint(metaUint(meta, "ip_version"))
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%s: unsupported record size %d", file, db.recordSize)
+	}
+	db.treeSize = db.recordSize * 2 / 8 * db.nodeCount
+	if db.treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("%s: search tree is truncated", file)
+	}
+	db.data = buf[db.treeSize+16 : i]
+	return db, nil
+}
+
+func metaUint(meta map[string]interface{}, key string) uint64 {
+	v, _ := meta[key].(uint64)
+	return v
+}
+
+// lookup returns the record for ip, or nil if the database has none.
+func (db *mmdb) lookup(ip net.IP) (map[string]interface{}, error) {
+	bits := ip.To16()
+	if v4 := ip.To4(); v4 != nil {
+		if db.ipVersion == 4 {
+			bits = v4
+		} else {
+			// IPv4 lives in ::/96 of an IPv6 tree
+			bits = append(make(net.IP, 12), v4...)
+		}
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	node := uint(0)
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, bits[i/8]>>(7-uint(i%8))&1)
+	}
+	if node <= db.nodeCount {
+		return nil, nil
+	}
+	offset := node - db.nodeCount - 16
+	if offset >= uint(len(db.data)) {
+		return nil, errors.New("mmdb: record points outside the data section")
+	}
+	v, _, err := decodeMMDB(db.data, offset)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := v.(map[string]interface{})
+	return m, nil
+}
+
+// record returns the left or right record of a search tree node.
+func (db *mmdb) record(node uint, right byte) uint {
+	b := db.buf[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[3*uint(right):]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if right == 1 {
+			return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+		}
+		return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	default:
+		return uint(binary.BigEndian.Uint32(b[4*uint(right):]))
+	}
+}
+
+// mmdbMaxDepth bounds how deeply values and pointers may nest, so a
+// corrupt database with a pointer loop fails instead of recursing forever.
+const mmdbMaxDepth = 32
+
+// decodeMMDB decodes the value at offset in a data section, returning it
+// and the offset following it.
+func decodeMMDB(data []byte, offset uint) (interface{}, uint, error) {
+	return decodeDepth(data, offset, 0)
+}
+
+func decodeDepth(data []byte, offset uint, depth int) (interface{}, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("mmdb: values nest too deeply")
+	}
+	short := errors.New("mmdb: data section is truncated")
+	next := func(n uint) ([]byte, error) {
+		if offset+n > uint(len(data)) {
+			return nil, short
+		}
+		b := data[offset : offset+n]
+		offset += n
+		return b, nil
+	}
+
+	b, err := next(1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	kind := uint(ctrl >> 5)
+
+	if kind == 1 {
+		ss, vvv := uint(ctrl>>3&3), uint(ctrl&7)
+		b, err := next(ss + 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		var p uint
+		switch ss {
+		case 0:
+			p = vvv<<8 | uint(b[0])
+		case 1:
+			p = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		v, _, err := decodeDepth(data, p, depth+1)
+		return v, offset, err
+	}
+
+	if kind == 0 {
+		b, err := next(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(b[0])
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := next(n)
+		if err != nil {
+			return nil, 0, err
+		}
+		var extra uint
+		for _, c := range b {
+			extra = extra<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[n-1] + extra
+	}
+	if size > uint(len(data)) {
+		// every byte, entry or element takes at least a byte
+		return nil, 0, short
+	}
+
+	switch kind {
+	case 2, 4:
+		b, err := next(size)
+		if err != nil {
+			return nil, 0, err
+		}
+		if kind == 4 {
+			return append([]byte(nil), b...), offset, nil
+		}
+		return string(b), offset, nil
+	case 3:
+		b, err := next(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15:
+		b, err := next(4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 5, 6, 8, 9, 10:
+		b, err := next(size)
+		if err != nil {
+			return nil, 0, err
+		}
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if kind == 8 {
+			return int64(int32(v)), offset, nil
+		}
+		return v, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	case 7:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var k, v interface{}
+			if k, offset, err = decodeDepth(data, offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			if v, offset, err = decodeDepth(data, offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("mmdb: map key is not a string")
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case 11:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var v interface{}
+			if v, offset, err = decodeDepth(data, offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	}
+	return nil, 0, fmt.Errorf("mmdb: unsupported data type %d", kind)
+}
@@ -0,0 +1,319 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// mmdbWriter builds small MaxMind DB fixtures.
+type mmdbWriter struct {
+	ipVersion  int
+	recordSize int
+	nodes      [][2]int // child node, or -1
+	leaves     [][2]int // data offset + 1, or 0
+	data       bytes.Buffer
+}
+
+func newMMDBWriter(ipVersion, recordSize int) *mmdbWriter {
+	w := &mmdbWriter{ipVersion: ipVersion, recordSize: recordSize}
+	w.node()
+	return w
+}
+
+func (w *mmdbWriter) node() int {
+	w.nodes = append(w.nodes, [2]int{-1, -1})
+	w.leaves = append(w.leaves, [2]int{})
+	return len(w.nodes) - 1
+}
+
+// control writes the control bytes of a value of kind and size.
+func control(b *bytes.Buffer, kind, size int) {
+	ctrl := byte(kind << 5)
+	if kind > 7 {
+		ctrl = 0
+	}
+	var extra []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 285:
+		ctrl |= 29
+		extra = []byte{byte(size - 29)}
+	case size < 65821:
+		ctrl |= 30
+		extra = []byte{byte((size - 285) >> 8), byte(size - 285)}
+	default:
+		ctrl |= 31
+		extra = []byte{byte((size - 65821) >> 16), byte((size - 65821) >> 8), byte(size - 65821)}
+	}
+	b.WriteByte(ctrl)
+	if kind > 7 {
+		b.WriteByte(byte(kind - 7))
+	}
+	b.Write(extra)
+}
+
+// pointer is a value written as a pointer to an offset in the data.
+type pointer int
+
+// encode appends v, a map, string, pointer or unsigned number, to b.
+func encode(b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		control(b, 2, len(v))
+		b.WriteString(v)
+	case pointer:
+		b.WriteByte(1<<5 | 3<<3)
+		binary.Write(b, binary.BigEndian, uint32(v))
+	case int:
+		var n []byte
+		for u := uint64(v); u > 0; u >>= 8 {
+			n = append([]byte{byte(u)}, n...)
+		}
+		control(b, 9, len(n))
+		b.Write(n)
+	case map[string]interface{}:
+		control(b, 7, len(v))
+		var keys []string
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encode(b, k)
+			encode(b, v[k])
+		}
+	default:
+		panic("can't encode value")
+	}
+}
+
+// value adds v to the data section and returns its offset.
+func (w *mmdbWriter) value(v interface{}) int {
+	offset := w.data.Len()
+	encode(&w.data, v)
+	return offset
+}
+
+// insert points network at the data at offset.
+func (w *mmdbWriter) insert(network string, offset int) {
+	_, n, err := net.ParseCIDR(network)
+	if err != nil {
+		panic(err)
+	}
+	ip, ones := n.IP.To16(), 0
+	if v4 := n.IP.To4(); v4 != nil {
+		ip = v4
+		if w.ipVersion == 6 {
+			ip = append(make(net.IP, 12), v4...)
+			ones = 96
+		}
+	}
+	o, _ := n.Mask.Size()
+	ones += o
+
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := int(ip[i/8] >> (7 - uint(i%8)) & 1)
+		if i == ones-1 {
+			w.leaves[node][bit] = offset + 1
+			break
+		}
+		if w.nodes[node][bit] < 0 {
+			child := w.node()
+			w.nodes[node][bit] = child
+		}
+		node = w.nodes[node][bit]
+	}
+}
+
+// bytes returns the database.
+func (w *mmdbWriter) bytes() []byte {
+	count := len(w.nodes)
+	var out bytes.Buffer
+	for i := range w.nodes {
+		var rec [2]uint32
+		for bit := 0; bit < 2; bit++ {
+			switch {
+			case w.nodes[i][bit] >= 0:
+				rec[bit] = uint32(w.nodes[i][bit])
+			case w.leaves[i][bit] > 0:
+				rec[bit] = uint32(count + 16 + w.leaves[i][bit] - 1)
+			default:
+				rec[bit] = uint32(count)
+			}
+		}
+		switch w.recordSize {
+		case 24:
+			out.Write([]byte{byte(rec[0] >> 16), byte(rec[0] >> 8), byte(rec[0]),
+				byte(rec[1] >> 16), byte(rec[1] >> 8), byte(rec[1])})
+		case 28:
+			out.Write([]byte{byte(rec[0] >> 16), byte(rec[0] >> 8), byte(rec[0]),
+				byte(rec[0]>>20&0xf0 | rec[1]>>24&0x0f),
+				byte(rec[1] >> 16), byte(rec[1] >> 8), byte(rec[1])})
+		case 32:
+			binary.Write(&out, binary.BigEndian, rec)
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(w.data.Bytes())
+	out.Write(mmdbMarker)
+	encode(&out, map[string]interface{}{
+		"node_count":    count,
+		"record_size":   w.recordSize,
+		"ip_version":    w.ipVersion,
+		"database_type": "Test",
+	})
+	return out.Bytes()
+}
+
+// writeFixture writes b to a file for the rest of the test.
+func writeFixture(t *testing.T, name string, b []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// countryFixture is a database placing a few documentation networks,
+// sharing their country through a pointer.
+func countryFixture(ipVersion, recordSize int) []byte {
+	w := newMMDBWriter(ipVersion, recordSize)
+	au := w.value(map[string]interface{}{"iso_code": "AU"})
+	w.insert("192.0.2.0/24", w.value(map[string]interface{}{
+		"country":                        pointer(au),
+		"autonomous_system_number":       64500,
+		"autonomous_system_organization": "Example Net",
+	}))
+	w.insert("198.51.100.0/25", w.value(map[string]interface{}{
+		"registered_country": map[string]interface{}{"iso_code": "NZ"},
+	}))
+	if ipVersion == 6 {
+		w.insert("2001:db8::/32", w.value(map[string]interface{}{"country": pointer(au)}))
+	}
+	return w.bytes()
+}
+
+func TestMMDBLookup(t *testing.T) {
+	for _, version := range []int{4, 6} {
+		for _, size := range []int{24, 28, 32} {
+			db, err := openMMDB(writeFixture(t, "test.mmdb", countryFixture(version, size)))
+			if err != nil {
+				t.Fatalf("v%d/%d: %v", version, size, err)
+			}
+			tests := []struct {
+				ip      string
+				country string
+				asn     uint64
+			}{
+				{"192.0.2.1", "AU", 64500},
+				{"192.0.2.255", "AU", 64500},
+				{"198.51.100.7", "NZ", 0},
+				{"198.51.100.200", "", 0},
+				{"203.0.113.1", "", 0},
+				{"2001:db8::1", "AU", 0},
+			}
+			for _, tt := range tests {
+				rec, err := db.lookup(net.ParseIP(tt.ip))
+				if err != nil {
+					t.Errorf("v%d/%d %s: %v", version, size, tt.ip, err)
+					continue
+				}
+				if version == 4 && net.ParseIP(tt.ip).To4() == nil {
+					tt.country = ""
+				}
+				country := isoCode(rec, "country")
+				if country == "" {
+					country = isoCode(rec, "registered_country")
+				}
+				asn, _ := rec["autonomous_system_number"].(uint64)
+				if country != tt.country || asn != tt.asn {
+					t.Errorf("v%d/%d %s: got %q AS%d, want %q AS%d", version, size, tt.ip, country, asn, tt.country, tt.asn)
+				}
+			}
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	db, err := openMMDB(writeFixture(t, "test.mmdb", countryFixture(6, 28)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := geoSources
+	defer func() { geoSources = saved }()
+	geoSources.dbs, geoSources.asn = []*mmdb{db}, nil
+
+	g, ok := locate("192.0.2.53:5353")
+	if want := (geo{"AU", 64500, "Example Net"}); !ok || g != want {
+		t.Errorf("got %v %v, want %v", g, ok, want)
+	}
+	if _, ok := locate("203.0.113.1"); ok {
+		t.Error("located an address the database doesn't have")
+	}
+}
+
+func TestMMDBCorrupt(t *testing.T) {
+	// a pointer to itself
+	loop := newMMDBWriter(4, 24)
+	loop.insert("192.0.2.0/24", loop.value(pointer(0)))
+
+	// maps nested deeper than any real database
+	deep := newMMDBWriter(4, 24)
+	var v interface{} = "bottom"
+	for i := 0; i < 2*mmdbMaxDepth; i++ {
+		v = map[string]interface{}{"a": v}
+	}
+	deep.insert("192.0.2.0/24", deep.value(v))
+
+	// a map claiming far more entries than the data holds
+	huge := newMMDBWriter(4, 24)
+	var b bytes.Buffer
+	control(&b, 7, 100000)
+	huge.data.Write(b.Bytes())
+	huge.insert("192.0.2.0/24", 0)
+
+	for name, w := range map[string]*mmdbWriter{"loop": loop, "deep": deep, "huge": huge} {
+		db, err := openMMDB(writeFixture(t, name+".mmdb", w.bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := db.lookup(net.ParseIP("192.0.2.1")); err == nil {
+			t.Errorf("%s: looked up without an error", name)
+		}
+	}
+
+	good := countryFixture(4, 24)
+	for name, b := range map[string][]byte{
+		"no marker": good[:bytes.LastIndex(good, mmdbMarker)],
+		"no tree":   good[bytes.LastIndex(good, mmdbMarker)-20:],
+	} {
+		if _, err := openMMDB(writeFixture(t, "bad.mmdb", b)); err == nil {
+			t.Errorf("%s: opened", name)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"crypto"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// The mock resolvers let the scheduler, retries, report and behaviour
+// checks be tested offline. Each serves on loopback and injects latency
+// and faults as its fields say.
+
+// mockResolver answers A queries for the names in the host lists with an
+// address derived from the name, so every mock server agrees, and NXDOMAIN
+// for anything else, after injecting latency and faults.
+type mockResolver struct {
+	name      string
+	instances int
+	latency   time.Duration
+	drop      float64
+	servfail  float64
+	truncate  float64
+	hijack    float64
+	nxhijack  float64
+	block     string
+	dnssec    string
+	ecs       string
+	qcase     string
+	spoof     float64
+	nochaos   bool            // drop CHAOS class queries
+	blocked   map[string]bool // names to block, resolved when block is "none"
+
+	inflight, peak int32 // queries being answered, and the most at once
+}
+
+// names the mock resolvers know about
+var mockZone = make(map[string]bool)
+
+// addresses the mock resolvers block with
+var (
+	mockSinkholeIP  = net.IPv4(127, 0, 0, 2)
+	mockBlockPageIP = net.IPv4(203, 0, 113, 99)
+)
+
+func init() {
+	for _, l := range []List{Top, Hosts} {
+		for _, h := range l {
+			mockZone[dns.Fqdn(strings.ToLower(h))] = true
+		}
+	}
+}
+
+// address the mock resolvers hand out when hijacking an answer
+var mockHijackIP = net.IPv4(203, 0, 113, 66)
+
+func (m *mockResolver) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	n := atomic.AddInt32(&m.inflight, 1)
+	for {
+		peak := atomic.LoadInt32(&m.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(&m.peak, peak, n) {
+			break
+		}
+	}
+
+	instance := 0
+	if m.instances > 1 {
+		instance = rand.Intn(m.instances)
+	}
+	time.Sleep(m.latency + time.Duration(instance)*10*time.Millisecond)
+	// done before replying, as the client may send its next query the
+	// moment the answer arrives
+	atomic.AddInt32(&m.inflight, -1)
+	if rand.Float64() < m.drop {
+		return
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+	identity := fmt.Sprintf("%s.%d", m.name, instance)
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), opt.Do())
+		for _, o := range opt.Option {
+			if o.Option() == dns.EDNS0NSID {
+				addOption(resp, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte(identity))})
+			}
+		}
+	}
+	if len(req.Question) == 1 && req.Question[0].Qclass == dns.ClassCHAOS {
+		if m.nochaos {
+			return
+		}
+		q := req.Question[0]
+		switch strings.ToLower(q.Name) {
+		case "id.server.", "hostname.bind.":
+			resp.Answer = append(resp.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+				Txt: []string{identity},
+			})
+		default:
+			resp.Rcode = dns.RcodeRefused
+		}
+		m.reply(w, resp)
+		return
+	}
+	_, udp := w.RemoteAddr().(*net.UDPAddr)
+
+	switch {
+	case rand.Float64() < m.servfail:
+		resp.Rcode = dns.RcodeServerFailure
+	case udp && rand.Float64() < m.truncate:
+		resp.Truncated = true
+	case rand.Float64() < m.hijack:
+		for _, q := range req.Question {
+			if q.Qtype == dns.TypeA {
+				a := mockA(q.Name).(*dns.A)
+				a.A = mockHijackIP
+				resp.Answer = append(resp.Answer, a)
+			}
+		}
+	default:
+		for _, q := range req.Question {
+			if m.answerSigned(resp, req, q) || m.answerECS(resp, req, q) {
+				continue
+			}
+			name := strings.ToLower(q.Name)
+			if m.blocked[name] && m.block != "" && m.block != "none" {
+				if !m.blockAnswer(resp, q) {
+					resp.Rcode = dns.RcodeNameError
+				}
+				continue
+			}
+			if !mockZone[name] && !m.blocked[name] {
+				if rand.Float64() < m.nxhijack {
+					a := mockA(q.Name).(*dns.A)
+					a.A = mockHijackIP
+					resp.Answer = append(resp.Answer, a)
+				} else {
+					resp.Rcode = dns.RcodeNameError
+				}
+				continue
+			}
+			if q.Qtype == dns.TypeA {
+				resp.Answer = append(resp.Answer, mockA(q.Name))
+			}
+		}
+	}
+	m.reply(w, resp)
+}
+
+// reply writes resp after applying the resolver's query name case and
+// reply source faults.
+func (m *mockResolver) reply(w dns.ResponseWriter, resp *dns.Msg) {
+	switch m.qcase {
+	case "lower":
+		for i := range resp.Question {
+			resp.Question[i].Name = strings.ToLower(resp.Question[i].Name)
+		}
+		for _, rr := range resp.Answer {
+			rr.Header().Name = strings.ToLower(rr.Header().Name)
+		}
+	case "0x20":
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for i, rr := range resp.Answer {
+			rr = dns.Copy(rr)
+			rr.Header().Name = mix0x20(rr.Header().Name, r)
+			resp.Answer[i] = rr
+		}
+	}
+
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp && rand.Float64() < m.spoof {
+		packed, err := resp.Pack()
+		if err != nil {
+			return
+		}
+		conn, err := net.ListenPacket("udp", ":0")
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteTo(packed, w.RemoteAddr())
+		return
+	}
+	w.WriteMsg(resp)
+}
+
+// blockAnswer answers a blocked question the way m blocks, returning false
+// when it blocks with NXDOMAIN.
+func (m *mockResolver) blockAnswer(resp *dns.Msg, q dns.Question) bool {
+	var ip net.IP
+	switch m.block {
+	case "null":
+		ip = net.IPv4zero
+	case "sinkhole":
+		ip = mockSinkholeIP
+	case "blockpage":
+		ip = mockBlockPageIP
+	default:
+		return false
+	}
+	if q.Qtype == dns.TypeA {
+		a := mockA(q.Name).(*dns.A)
+		a.A = ip
+		resp.Answer = append(resp.Answer, a)
+	}
+	return true
+}
+
+func mockA(name string) dns.RR {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(name)))
+	sum := h.Sum32()
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.IPv4(198, 18, byte(sum>>8), byte(sum)),
+	}
+}
+
+// startMock serves m over UDP and TCP on the IPv4 loopback, or the IPv6
+// one for "::1", for the rest of the test and returns its address.
+func startMock(t testing.TB, m *mockResolver, host string) string {
+	t.Helper()
+	mockSetup.Do(func() {
+		mockSetupErr = signMockZones()
+	})
+	if mockSetupErr != nil {
+		t.Fatal(mockSetupErr)
+	}
+	if m.name == "" {
+		m.name = "mock"
+	}
+	for try := 0; try < 10; try++ {
+		pc, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			// port is taken for TCP, try another
+			pc.Close()
+			continue
+		}
+		udp := &dns.Server{PacketConn: pc, Handler: m}
+		tcp := &dns.Server{Listener: l, Handler: m}
+		go udp.ActivateAndServe()
+		go tcp.ActivateAndServe()
+		t.Cleanup(func() {
+			udp.Shutdown()
+			tcp.Shutdown()
+		})
+		return pc.LocalAddr().String()
+	}
+	t.Fatal("no port free for both UDP and TCP")
+	return ""
+}
+
+var (
+	mockSetup    sync.Once
+	mockSetupErr error
+)
+
+// discard is an observer that shows nothing, to keep test output quiet.
+type discard struct{}
+
+func (discard) start([]Query) {}
+func (discard) result(Result) {}
+func (discard) done()         {}
+
+// setFlag sets a flag for the rest of the test.
+func setFlag(t testing.TB, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Value.Set(old) })
+}
+
+// Zones the mock resolvers serve for the DNSSEC check.
+const (
+	mockSignedName = "www.signed.test."
+	mockBrokenName = "www.broken.test."
+)
+
+// mockSignedZone holds an A record for a name with its RRSIG, and the key
+// that should validate it.
+type mockSignedZone struct {
+	a    *dns.A
+	sig  *dns.RRSIG
+	key  *dns.DNSKEY
+	good bool
+}
+
+var mockSigned = make(map[string]*mockSignedZone)
+
+// signMockZones signs the mock zones, breaking the signature of one.
+func signMockZones() error {
+	for _, name := range []string{mockSignedName, mockBrokenName} {
+		zone := dns.Fqdn(name[len("www."):])
+		key := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 300},
+			Flags:     257,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		priv, err := key.Generate(256)
+		if err != nil {
+			return err
+		}
+		a := &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   net.IPv4(198, 18, 0, 53),
+		}
+		now := time.Now()
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+			Inception:  uint32(now.Add(-time.Hour).Unix()),
+			Expiration: uint32(now.Add(24 * time.Hour).Unix()),
+			KeyTag:     key.KeyTag(),
+			SignerName: zone,
+			Algorithm:  key.Algorithm,
+		}
+		if err := sig.Sign(priv.(crypto.Signer), []dns.RR{a}); err != nil {
+			return err
+		}
+		z := &mockSignedZone{a: a, sig: sig, key: key, good: name == mockSignedName}
+		if !z.good {
+			// sign one address, then serve another
+			a.A = net.IPv4(198, 18, 0, 54)
+		}
+		mockSigned[name] = z
+	}
+	return nil
+}
+
+// answerSigned answers a question for one of the signed mock zones the way
+// the resolver's dnssec mode says, returning false if q isn't for one.
+func (m *mockResolver) answerSigned(resp, req *dns.Msg, q dns.Question) bool {
+	z := mockSigned[q.Name]
+	if z == nil {
+		return false
+	}
+	valid := z.sig.Verify(z.key, []dns.RR{z.a}) == nil && z.sig.ValidityPeriod(time.Now())
+	switch {
+	case m.dnssec == "broken", m.dnssec == "validate" && !valid:
+		resp.Rcode = dns.RcodeServerFailure
+		return true
+	case m.dnssec == "validate":
+		resp.AuthenticatedData = true
+	}
+	if q.Qtype == dns.TypeA {
+		resp.Answer = append(resp.Answer, z.a)
+		if opt := req.IsEdns0(); opt != nil && opt.Do() {
+			resp.Answer = append(resp.Answer, z.sig)
+		}
+	}
+	return true
+}
+
+// answerECS echoes the client subnet in req the way the resolver's ecs mode
+// says, and answers the -ecs-probe name with the subnet an authoritative
+// server would have seen. It returns false if q isn't for the probe name.
+// The modes are honour, replace (forward a subnet of its own), echo (echo
+// but don't forward), strip, and forward otherwise.
+func (m *mockResolver) answerECS(resp, req *dns.Msg, q dns.Question) bool {
+	e := ecsOf(req)
+	if e != nil && m.ecs != "strip" {
+		echo := *e
+		if m.ecs == "honour" {
+			echo.SourceScope = echo.SourceNetmask
+		}
+		addOption(resp, &echo)
+	}
+
+	if !strings.EqualFold(q.Name, dns.Fqdn(*ecsProbe)) {
+		return false
+	}
+	if q.Qtype == dns.TypeTXT {
+		txt := []string{"127.0.0.1"}
+		switch {
+		case e == nil, m.ecs == "strip", m.ecs == "echo":
+		case m.ecs == "replace":
+			txt = append(txt, "edns0-client-subnet 127.0.0.0/24")
+		default:
+			txt = append(txt, fmt.Sprintf("edns0-client-subnet %v/%d", e.Address, e.SourceNetmask))
+		}
+		resp.Answer = append(resp.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+			Txt: txt,
+		})
+	}
+	return true
+}
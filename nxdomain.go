@@ -0,0 +1,72 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	nxCheck = flag.Bool("nxcheck", false, "Check whether servers rewrite NXDOMAIN answers")
+	nxCount = flag.Int("nxcount", 3, "Number of nonexistent names queried by the NXDOMAIN check")
+)
+
+// randomName returns a name under .com that almost certainly doesn't
+// exist.
+func randomName(r *rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 24)
+	for i := range b {
+		b[i] = letters[r.Intn(len(letters))]
+	}
+	return "nx-" + string(b) + ".com."
+}
+
+// nxdomainColumn queries each server for random nonexistent names. A server
+// that answers any of them with an address is rewriting NXDOMAIN, usually
+// to an ad or search page.
+func nxdomainColumn(servers []string) column {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	names := make([]string, *nxCount)
+	for i := range names {
+		names[i] = randomName(r)
+	}
+
+	return probeAll("nxdomain", servers, func(server string) string {
+		status := "error"
+		for _, name := range names {
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeA)
+			ans, _, err := exchange(server, m)
+			if err != nil {
+				continue
+			}
+			if len(addresses(ans)) > 0 {
+				return "HIJACK"
+			}
+			if ans.Rcode == dns.RcodeNameError {
+				status = "ok"
+			}
+		}
+		return status
+	})
+}
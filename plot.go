@@ -0,0 +1,417 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var plotFile = flag.String("plot", "", "Draw latency histograms, CDF curves and a time series of every query to this .svg or .png file")
+
+// canvas is what the plots are drawn on, an SVG document or a PNG image.
+type canvas interface {
+	line(x1, y1, x2, y2 float64, colour string)
+	box(x, y, w, h float64, colour string)
+	frame(x, y, w, h float64, colour string) // a box's outline
+	dot(x, y float64, colour string)
+	// text draws s with its baseline at y. anchor is 0 to start s at x,
+	// 0.5 to centre it and 1 to end it there.
+	text(x, y float64, s string, anchor float64)
+}
+
+// Plot geometry, in pixels.
+const (
+	plotWidth     = 900
+	plotLeft      = 190
+	plotRight     = 30
+	histHeight    = 46
+	boxHeight     = 28
+	panelHeight   = 240
+	axisGap       = 40
+	histogramBins = 60
+)
+
+const (
+	axisColour = "#333333"
+	gridColour = "#dddddd"
+)
+
+// palette colours each server the same in every chart.
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// series is every server's response times in milliseconds, sorted, with
+// the colour and scale every chart draws them in.
+type series struct {
+	names   []string
+	colours map[string]string
+	samples map[string][]float64
+	scale   float64 // the slowest server's 99th percentile, and a margin
+}
+
+func newSeries(report Report) series {
+	s := series{colours: make(map[string]string), samples: make(map[string][]float64), scale: 1}
+	for k, v := range report {
+		s.names = append(s.names, v.label())
+		s.colours[v.label()] = palette[k%len(palette)]
+		ms := v.samples.millis()
+		sort.Float64s(ms)
+		s.samples[v.label()] = ms
+		if len(ms) > 0 {
+			s.scale = math.Max(s.scale, percentile(ms, 0.99))
+		}
+	}
+	s.scale *= 1.05
+	return s
+}
+
+// writePlot draws every server's latency distribution and every query
+// over the run, in the format the file name's extension asks for.
+func writePlot(file string, report Report, results []Result) error {
+	s := newSeries(report)
+	height := 40 + len(s.names)*histHeight + axisGap + 2*(30+panelHeight+axisGap)
+	render := func(c canvas) {
+		y := 20.0
+		c.text(plotLeft, y, fmt.Sprintf("Response times of %d queries", len(results)), 0)
+		y += 20
+		y = histograms(c, y, s)
+		y += 30
+		c.text(plotLeft, y-10, "Cumulative distribution", 0)
+		y = cdfPanel(c, y, s)
+		y += 30
+		c.text(plotLeft, y-10, "Every query over the run, failures marked along the top", 0)
+		timeSeries(c, y, results, s)
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".svg":
+		c := new(svgCanvas)
+		render(c)
+		return os.WriteFile(file, []byte(c.document(float64(height))+"\n"), 0644)
+	case ".png":
+		c := &pngCanvas{image.NewRGBA(image.Rect(0, 0, plotWidth, height))}
+		draw.Draw(c.img, c.img.Bounds(), image.White, image.Point{}, draw.Src)
+		render(c)
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, c.img); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return fmt.Errorf("%s: want a .svg or .png file", file)
+}
+
+// ticks returns about five round numbered axis ticks from 0 to max.
+func ticks(max float64) []float64 {
+	step := math.Pow(10, math.Floor(math.Log10(max/5)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if max/(step*m) <= 6 {
+			step *= m
+			break
+		}
+	}
+	var t []float64
+	for v := 0.0; v <= max; v += step {
+		t = append(t, v)
+	}
+	return t
+}
+
+// tickLabel formats an axis tick without the float noise of adding up
+// steps like 0.2.
+func tickLabel(t float64) string {
+	return strconv.FormatFloat(t, 'g', 6, 64)
+}
+
+// xScale maps v from 0 to max across the plot area.
+func xScale(v, max float64) float64 {
+	return plotLeft + math.Min(v, max)/max*(plotWidth-plotLeft-plotRight)
+}
+
+// plotAxis draws a horizontal axis at y from 0 to max.
+func plotAxis(c canvas, y, max float64, unit string) {
+	c.line(plotLeft, y, plotWidth-plotRight, y, axisColour)
+	for _, t := range ticks(max) {
+		x := xScale(t, max)
+		c.line(x, y, x, y+4, axisColour)
+		c.text(x, y+16, tickLabel(t), 0.5)
+	}
+	c.text(plotWidth-plotRight, y+30, unit, 1)
+}
+
+// histograms draws one histogram per server below y, each scaled to its
+// own tallest bin so the shape, a second cache miss peak or a long tail,
+// stands out. It returns the y below them.
+func histograms(c canvas, y float64, s series) float64 {
+	width := float64(plotWidth-plotLeft-plotRight) / histogramBins
+	for _, name := range s.names {
+		bins := make([]int, histogramBins)
+		over, most := 0, 1
+		for _, v := range s.samples[name] {
+			i := int(v / s.scale * histogramBins)
+			if i >= histogramBins {
+				over++
+				continue
+			}
+			bins[i]++
+			if bins[i] > most {
+				most = bins[i]
+			}
+		}
+		base := y + histHeight - 6
+		c.text(plotLeft-8, base-4, name, 1)
+		c.line(plotLeft, base, plotWidth-plotRight, base, gridColour)
+		for i, n := range bins {
+			if n == 0 {
+				continue
+			}
+			h := float64(n) / float64(most) * (histHeight - 10)
+			c.box(plotLeft+float64(i)*width, base-h, width-1, h, s.colours[name])
+		}
+		if over > 0 {
+			c.text(plotWidth-plotRight, base-4, fmt.Sprintf("+%d", over), 0)
+		}
+		y += histHeight
+	}
+	plotAxis(c, y, s.scale, "ms")
+	return y + axisGap
+}
+
+// boxPanel draws a box from the lower to the upper quartile of each
+// server's response times below y, with the median marked, whiskers
+// reaching the furthest samples within 1.5 times the box and the rest as
+// outliers. It returns the y below it.
+func boxPanel(c canvas, y float64, s series) float64 {
+	for _, name := range s.names {
+		ms := s.samples[name]
+		mid := y + boxHeight/2
+		c.text(plotLeft-8, mid+4, name, 1)
+		y += boxHeight
+		if len(ms) == 0 {
+			continue
+		}
+		q1, med, q3 := percentile(ms, 0.25), percentile(ms, 0.5), percentile(ms, 0.75)
+		lo, hi := q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+		wlo, whi := med, med
+		for _, v := range ms {
+			if v >= lo && v < wlo {
+				wlo = v
+			}
+			if v <= hi && v > whi {
+				whi = v
+			}
+		}
+		colour := s.colours[name]
+		c.line(xScale(wlo, s.scale), mid, xScale(whi, s.scale), mid, colour)
+		c.frame(xScale(q1, s.scale), mid-8, math.Max(1, xScale(q3, s.scale)-xScale(q1, s.scale)), 16, colour)
+		c.line(xScale(med, s.scale), mid-8, xScale(med, s.scale), mid+8, colour)
+		for _, v := range ms {
+			if (v < lo || v > hi) && v <= s.scale {
+				c.dot(xScale(v, s.scale), mid, colour)
+			}
+		}
+	}
+	plotAxis(c, y, s.scale, "ms")
+	return y + axisGap
+}
+
+// cdfPanel draws every server's cumulative distribution below y and
+// returns the y below it.
+func cdfPanel(c canvas, y float64, s series) float64 {
+	bottom := y + panelHeight
+	yOf := func(p float64) float64 { return bottom - p*panelHeight }
+	for _, p := range []float64{0, 0.25, 0.5, 0.75, 1} {
+		c.line(plotLeft, yOf(p), plotWidth-plotRight, yOf(p), gridColour)
+		c.text(plotLeft-8, yOf(p)+4, fmt.Sprintf("%g%%", 100*p), 1)
+	}
+	for _, name := range s.names {
+		ms := s.samples[name]
+		step := len(ms)/300 + 1
+		x0, y0 := xScale(0, s.scale), yOf(0)
+		for i := 0; i < len(ms); i += step {
+			x1, y1 := xScale(ms[i], s.scale), yOf(float64(i+1)/float64(len(ms)))
+			c.line(x0, y0, x1, y0, s.colours[name])
+			c.line(x1, y0, x1, y1, s.colours[name])
+			x0, y0 = x1, y1
+		}
+		if len(ms) > 0 {
+			c.line(x0, y0, xScale(ms[len(ms)-1], s.scale), yOf(1), s.colours[name])
+		}
+	}
+	plotAxis(c, bottom, s.scale, "ms")
+	return bottom + axisGap
+}
+
+// timeSeries plots the response time of every result against when it was
+// sent, below y.
+func timeSeries(c canvas, y float64, results []Result, s series) {
+	if len(results) == 0 {
+		return
+	}
+	first, last := results[0].at, results[0].at
+	for _, r := range results {
+		if r.at.Before(first) {
+			first = r.at
+		}
+		if r.at.After(last) {
+			last = r.at
+		}
+	}
+	span := math.Max(last.Sub(first).Seconds(), 1)
+
+	bottom := y + panelHeight
+	yOf := func(ms float64) float64 { return bottom - math.Min(ms, s.scale)/s.scale*panelHeight }
+	for _, t := range ticks(s.scale) {
+		c.line(plotLeft, yOf(t), plotWidth-plotRight, yOf(t), gridColour)
+		c.text(plotLeft-8, yOf(t)+4, tickLabel(t)+" ms", 1)
+	}
+	for _, r := range results {
+		colour, ok := s.colours[r.name()]
+		if !ok {
+			continue
+		}
+		x := xScale(r.at.Sub(first).Seconds(), span)
+		if !r.ok {
+			c.line(x-3, y-3, x+3, y+3, colour)
+			c.line(x-3, y+3, x+3, y-3, colour)
+			continue
+		}
+		c.dot(x, yOf(float64(r.rtt.Nanoseconds())/1e6), colour)
+	}
+	plotAxis(c, bottom, span, "seconds into the run")
+}
+
+// svgCanvas draws into an SVG document.
+type svgCanvas struct {
+	b strings.Builder
+}
+
+// document returns what was drawn as an SVG document height high.
+func (c *svgCanvas) document(height float64) string {
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%.0f" font-family="sans-serif" font-size="11">`, plotWidth, height) +
+		`<rect width="100%" height="100%" fill="#ffffff"/>` + c.b.String() + "</svg>"
+}
+
+func (c *svgCanvas) line(x1, y1, x2, y2 float64, colour string) {
+	fmt.Fprintf(&c.b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`, x1, y1, x2, y2, colour)
+}
+
+func (c *svgCanvas) box(x, y, w, h float64, colour string) {
+	fmt.Fprintf(&c.b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`, x, y, w, h, colour)
+}
+
+func (c *svgCanvas) frame(x, y, w, h float64, colour string) {
+	fmt.Fprintf(&c.b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="none" stroke="%s"/>`, x, y, w, h, colour)
+}
+
+func (c *svgCanvas) dot(x, y float64, colour string) {
+	fmt.Fprintf(&c.b, `<circle cx="%.1f" cy="%.1f" r="1.5" fill="%s" fill-opacity="0.6"/>`, x, y, colour)
+}
+
+func (c *svgCanvas) text(x, y float64, s string, anchor float64) {
+	align := "start"
+	switch {
+	case anchor == 0.5:
+		align = "middle"
+	case anchor == 1:
+		align = "end"
+	}
+	fmt.Fprintf(&c.b, `<text x="%.1f" y="%.1f" text-anchor="%s">%s</text>`, x, y, align, escapeXML(s))
+}
+
+func escapeXML(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// pngCanvas draws into an image.
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (c *pngCanvas) line(x1, y1, x2, y2 float64, colour string) {
+	col := parseColour(colour)
+	steps := math.Max(math.Abs(x2-x1), math.Abs(y2-y1))
+	for i := 0.0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = i / steps
+		}
+		c.img.Set(int(math.Round(x1+t*(x2-x1))), int(math.Round(y1+t*(y2-y1))), col)
+	}
+}
+
+func (c *pngCanvas) box(x, y, w, h float64, colour string) {
+	r := image.Rect(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h)))
+	draw.Draw(c.img, r, image.NewUniform(parseColour(colour)), image.Point{}, draw.Src)
+}
+
+func (c *pngCanvas) frame(x, y, w, h float64, colour string) {
+	c.line(x, y, x+w, y, colour)
+	c.line(x+w, y, x+w, y+h, colour)
+	c.line(x+w, y+h, x, y+h, colour)
+	c.line(x, y+h, x, y, colour)
+}
+
+func (c *pngCanvas) dot(x, y float64, colour string) {
+	col := parseColour(colour)
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			c.img.Set(int(math.Round(x))+dx, int(math.Round(y))+dy, col)
+		}
+	}
+}
+
+func (c *pngCanvas) text(x, y float64, s string, anchor float64) {
+	left := int(math.Round(x - anchor*float64(len([]rune(s))*glyphWidth)))
+	top := int(math.Round(y)) - 7
+	for i, r := range []rune(s) {
+		g, ok := glyphs[unicode.ToLower(r)]
+		if !ok {
+			continue
+		}
+		for row, bits := range g {
+			for col, b := range bits {
+				if b == '#' {
+					c.img.Set(left+i*glyphWidth+col, top+row, color.Black)
+				}
+			}
+		}
+	}
+}
+
+// parseColour reads a #rrggbb colour.
+func parseColour(s string) color.RGBA {
+	v, _ := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+}
@@ -0,0 +1,133 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"encoding/xml"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chartResults returns results for a fast, a slow and a dead server.
+func chartResults() []Result {
+	start := time.Now()
+	var results []Result
+	for i := 0; i < 50; i++ {
+		at := start.Add(time.Duration(i) * 10 * time.Millisecond)
+		results = append(results,
+			Result{server: "192.0.2.1", host: "example.com", at: at, ok: true, rtt: time.Duration(5+i%7) * time.Millisecond,
+				attempts: []attempt{{attemptOK, time.Duration(5+i%7) * time.Millisecond}}},
+			Result{server: "192.0.2.2", host: "example.com", at: at, ok: true, rtt: time.Duration(40+i) * time.Millisecond,
+				attempts: []attempt{{attemptOK, time.Duration(40+i) * time.Millisecond}}},
+			Result{server: "192.0.2.3", host: "example.com", at: at, errors: 1,
+				attempts: []attempt{{attemptTimeout, time.Second}}})
+	}
+	return results
+}
+
+// wellFormed checks s parses as XML.
+func wellFormed(t *testing.T, s string) {
+	t.Helper()
+	dec := xml.NewDecoder(strings.NewReader(s))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("not well formed: %v", err)
+		}
+	}
+}
+
+func TestWritePlot(t *testing.T) {
+	results := chartResults()
+	report, _ := buildReport(results)
+	dir := t.TempDir()
+
+	svg := filepath.Join(dir, "plot.svg")
+	if err := writePlot(svg, report, results); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(svg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wellFormed(t, string(b))
+	if strings.Contains(string(b), "NaN") {
+		t.Error("plot has NaN coordinates")
+	}
+
+	file := filepath.Join(dir, "plot.png")
+	if err := writePlot(file, report, results); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := img.Bounds().Dx(); w != plotWidth {
+		t.Errorf("png is %d wide, want %d", w, plotWidth)
+	}
+
+	if err := writePlot(filepath.Join(dir, "plot.gif"), report, results); err == nil {
+		t.Error("wrote a .gif")
+	}
+}
+
+func TestWriteHTMLCharts(t *testing.T) {
+	results := chartResults()
+	report, _ := buildReport(results)
+	file := filepath.Join(t.TempDir(), "report.html")
+	if err := writeHTML(file, report, results, runInfo{started: time.Now(), finished: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(b)
+	if n := strings.Count(page, "<svg"); n != 2 {
+		t.Fatalf("got %d charts, want the box plot and CDF", n)
+	}
+	for _, chart := range strings.Split(page, "<svg")[1:] {
+		chart = "<svg" + chart[:strings.Index(chart, "</svg>")] + "</svg>"
+		wellFormed(t, chart)
+		if strings.Contains(chart, "NaN") {
+			t.Error("chart has NaN coordinates")
+		}
+	}
+	if strings.Contains(page, "NaN") || strings.Contains(page, "Inf") {
+		t.Error("report shows the statistics of a server that never answered as numbers")
+	}
+	for _, server := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		if !strings.Contains(page, ">"+server+"</text>") {
+			t.Errorf("%s isn't labelled in the charts", server)
+		}
+	}
+}
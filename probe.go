@@ -0,0 +1,98 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// column is an extra per server value shown in the report, such as the
+// outcome of one of the behaviour checks.
+type column struct {
+	name   string
+	values map[string]string
+}
+
+// probeAll runs check against every server, at most -r at a time, and
+// collects the outcomes into a report column.
+func probeAll(name string, servers []string, check func(server string) string) column {
+	c := column{name: name, values: make(map[string]string)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	limit := make(chan bool, *numOResolvers)
+	for _, s := range servers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			limit <- true
+			v := check(server)
+			<-limit
+			mu.Lock()
+			c.values[server] = v
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+	return c
+}
+
+// exchange sends a single query to server the way the benchmark does,
+// over -protocol from the first source with -attempts tries of -timeout,
+// for checks that care about the answer rather than the timing.
+func exchange(server string, m *dns.Msg) (*dns.Msg, time.Duration, error) {
+	query := Query{server: server, source: probeSource()}
+	c := queryClient(*protocol, query)
+	addr := serverAddr(server, serverPort(*protocol))
+	var err error
+	for try := 0; try < *attempts; try++ {
+		time.Sleep(backoffDelay(try))
+		var ans *dns.Msg
+		var rtt time.Duration
+		ans, rtt, err = c.Exchange(m, addr)
+		if err == nil && ans.Truncated && c.Net == "udp" {
+			ans, rtt, err = queryClient("tcp", query).Exchange(m, addr)
+		}
+		if err == nil {
+			return ans, rtt, nil
+		}
+	}
+	return nil, 0, err
+}
+
+// printFlagged lists under title the servers for which a check came out
+// as one of the flagged values.
+func printFlagged(title string, c column, flagged ...string) {
+	var servers []string
+	for server, v := range c.values {
+		for _, f := range flagged {
+			if v == f {
+				servers = append(servers, server)
+			}
+		}
+	}
+	if len(servers) == 0 {
+		return
+	}
+	sort.Strings(servers)
+	fmt.Printf("\n%s: %v\n", title, servers)
+}
@@ -0,0 +1,112 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"time"
+)
+
+var (
+	timeout  = flag.Duration("timeout", 8*time.Second, "Time to wait for each attempt at a query")
+	attempts = flag.Int("attempts", 6, "Number of attempts at each query before giving up")
+	backoff  = flag.Duration("backoff", 0, "Wait before the first retry, doubled for each retry after")
+)
+
+// Outcomes of a single attempt at a query.
+const (
+	attemptOK      = "ok"
+	attemptTimeout = "timeout"
+	attemptError   = "error"
+
+	// a truncated UDP answer, asked again over TCP
+	attemptTruncated = "truncated"
+)
+
+// attempt records one try at a query. Failed attempts hold the time spent
+// waiting on them, or the rcode name when the server answered with an
+// error.
+type attempt struct {
+	outcome string
+	rtt     time.Duration
+}
+
+// maxBackoff caps the wait between retries, which doubling would
+// otherwise overflow after a few dozen attempts.
+const maxBackoff = time.Minute
+
+// checkRetries makes sure every query gets at least one attempt, and time
+// to be answered.
+func checkRetries() error {
+	if *attempts < 1 {
+		return fmt.Errorf("attempts %d: want 1 or more", *attempts)
+	}
+	if *timeout <= 0 {
+		return fmt.Errorf("timeout %v: want more than 0", *timeout)
+	}
+	return nil
+}
+
+// backoffDelay returns the wait before retry n, counting from 1.
+func backoffDelay(n int) time.Duration {
+	if *backoff <= 0 || n < 1 {
+		return 0
+	}
+	d := *backoff
+	for i := 1; i < n && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// outcomeOf names the failure err.
+func outcomeOf(err error) string {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return attemptTimeout
+	}
+	return attemptError
+}
+
+// firstTry returns the response times of the results that succeeded on
+// their first attempt, free of any retry.
+func firstTry(results []Result) Times {
+	var times Times
+	for _, r := range results {
+		if r.ok && len(r.attempts) > 0 && r.attempts[0].outcome == attemptOK {
+			times = append(times, r.attempts[0].rtt)
+		}
+	}
+	return times
+}
+
+// effective returns the time each successful result took from its first
+// attempt, including failed attempts and backoff.
+func effective(results []Result) Times {
+	var times Times
+	for _, r := range results {
+		if r.ok {
+			times = append(times, r.effective)
+		}
+	}
+	return times
+}
@@ -0,0 +1,93 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	setFlag(t, "backoff", "100ms")
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{0, 0},
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{10, 51200 * time.Millisecond},
+		{11, maxBackoff},
+		{64, maxBackoff},
+		{1000, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoffDelay(tt.n); got != tt.want {
+			t.Errorf("backoffDelay(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRetries(t *testing.T) {
+	for _, n := range []string{"0", "-1"} {
+		setFlag(t, "attempts", n)
+		if checkRetries() == nil {
+			t.Errorf("-attempts %s accepted", n)
+		}
+	}
+	setFlag(t, "attempts", "1")
+	if err := checkRetries(); err != nil {
+		t.Error(err)
+	}
+	setFlag(t, "timeout", "0s")
+	if checkRetries() == nil {
+		t.Error("-timeout 0 accepted")
+	}
+}
+
+func TestExchangeFollowsFlags(t *testing.T) {
+	quick(t)
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(Top[0]), dns.TypeA)
+
+	truncating := startMock(t, &mockResolver{truncate: 1}, "127.0.0.1")
+	ans, _, err := exchange(truncating, m)
+	if err != nil || ans.Truncated || len(ans.Answer) != 1 {
+		t.Errorf("truncated answer not asked again over TCP: %v %v", ans, err)
+	}
+
+	setFlag(t, "protocol", "tcp")
+	tcp := &mockResolver{}
+	if _, _, err := exchange(startMock(t, tcp, "127.0.0.1"), m); err != nil {
+		t.Errorf("over TCP: %v", err)
+	}
+
+	setFlag(t, "protocol", "udp")
+	setFlag(t, "attempts", "2")
+	dead := startMock(t, &mockResolver{drop: 1}, "127.0.0.1")
+	start := time.Now()
+	if _, _, err := exchange(dead, m); err == nil {
+		t.Error("got an answer from a server that drops everything")
+	}
+	if took := time.Since(start); took < 200*time.Millisecond || took > time.Second {
+		t.Errorf("two attempts of 100ms took %v", took)
+	}
+}
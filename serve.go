@@ -0,0 +1,356 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var listen = flag.String("listen", "127.0.0.1:8053", "Address the serve and coordinator subcommands listen on, eg. :8053 to take requests from other hosts")
+
+// Limits on what one API request can ask for, and how much is kept.
+const (
+	maxHosts   = 10000 // lookups on each server in one run
+	maxServers = 100
+	keepRuns   = 50 // finished runs kept for fetching, the oldest are forgotten
+)
+
+// Run states reported by the API.
+const (
+	runQueued  = "queued"
+	runRunning = "running"
+	runDone    = "done"
+)
+
+// apiRun is a benchmark started through the API. It watches its own
+// queries to report progress.
+type apiRun struct {
+	mu        sync.Mutex
+	id        int
+	bench     benchmark
+	state     string
+	created   time.Time
+	started   time.Time
+	finished  time.Time
+	planned   int
+	completed int
+	results   []Result
+	report    Report
+}
+
+func (run *apiRun) start(queries []Query) {
+	run.mu.Lock()
+	run.planned += len(queries)
+	run.mu.Unlock()
+}
+
+func (run *apiRun) result(Result) {
+	run.mu.Lock()
+	run.completed++
+	run.mu.Unlock()
+}
+
+func (run *apiRun) done() {}
+
+// JSONStatus is the state of a run started through the API.
+type JSONStatus struct {
+	ID       int        `json:"id"`
+	Status   string     `json:"status"`
+	Protocol string     `json:"protocol"`
+	Servers  []string   `json:"servers"`
+	Hosts    int        `json:"hosts"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Planned  int        `json:"planned"`
+	Done     int        `json:"done"`
+}
+
+// JSONResults is a finished run's report and every result behind it.
+type JSONResults struct {
+	JSONStatus
+	Report  []JSONRecord `json:"report"`
+	Results []JSONResult `json:"results"`
+}
+
+func (run *apiRun) status() JSONStatus {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	s := JSONStatus{
+		ID:       run.id,
+		Status:   run.state,
+		Protocol: run.bench.protocol,
+		Servers:  run.bench.servers,
+		Hosts:    len(run.bench.hosts),
+		Created:  run.created,
+		Planned:  run.planned,
+		Done:     run.completed,
+	}
+	if !run.started.IsZero() {
+		t := run.started
+		s.Started = &t
+	}
+	if !run.finished.IsZero() {
+		t := run.finished
+		s.Finished = &t
+	}
+	return s
+}
+
+// runRequest asks for a run. Servers default to the usual benchmark
+// servers, hosts to count random domains. Given hosts and a count, count
+// of them are picked at random.
+type runRequest struct {
+	Servers  []string `json:"servers"`
+	Hosts    []string `json:"hosts"`
+	Protocol string   `json:"protocol"`
+	Count    int      `json:"count"`
+	Local    bool     `json:"local"` // also query the local resolver
+}
+
+// benchmark checks the request and turns it into the run it asks for.
+func (req runRequest) benchmark() (benchmark, error) {
+	b := benchmark{servers: req.Servers, protocol: req.Protocol, local: req.Local}
+	if b.protocol == "" {
+		b.protocol = *protocol
+	}
+	if err := checkProtocol(b.protocol); err != nil {
+		return b, err
+	}
+	if len(b.servers) == 0 {
+		b.servers = benchServers()
+	}
+	for _, s := range b.servers {
+		host := s
+		if h, _, err := net.SplitHostPort(s); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			return b, fmt.Errorf("server %q: want an address", s)
+		}
+	}
+	if len(b.servers) > maxServers {
+		return b, fmt.Errorf("%d servers: want at most %d", len(b.servers), maxServers)
+	}
+	if req.Count < 0 || req.Count > maxHosts {
+		return b, fmt.Errorf("count %d: want 0 to %d", req.Count, maxHosts)
+	}
+	if len(req.Hosts) > maxHosts {
+		return b, fmt.Errorf("%d hosts: want at most %d", len(req.Hosts), maxHosts)
+	}
+	count := req.Count
+	if count == 0 {
+		count = *numOfQueries
+	}
+	switch {
+	case len(req.Hosts) == 0:
+		b.hosts = selectHosts(count)
+	case req.Count > 0:
+		b.hosts = List(req.Hosts).randomSelect(count)
+	default:
+		b.hosts = req.Hosts
+	}
+	return b, nil
+}
+
+// api runs benchmarks one at a time, so they don't skew each other's
+// timings, and keeps the latest runs for fetching.
+type api struct {
+	mu    sync.Mutex
+	runs  []*apiRun // oldest first
+	last  int       // ID of the latest run
+	queue chan *apiRun
+}
+
+// serve answers the HTTP API on -listen:
+//
+//	POST /runs               start a run, the body is a runRequest
+//	GET  /runs               status of every run
+//	GET  /runs/{id}          status of a run
+//	GET  /runs/{id}/results  report and results of a finished run
+func serve() error {
+	a := &api{queue: make(chan *apiRun, 100)}
+	go a.worker()
+	fmt.Printf("\nServing the benchmark API on %v\n", *listen)
+	return http.ListenAndServe(*listen, a.handler())
+}
+
+func (a *api) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/runs", a.handleRuns)
+	mux.HandleFunc("/runs/", a.handleRun)
+	return mux
+}
+
+func (a *api) worker() {
+	for run := range a.queue {
+		run.mu.Lock()
+		run.state = runRunning
+		run.started = time.Now()
+		b := run.bench
+		run.mu.Unlock()
+		fmt.Printf("run %d: %d hosts on %d servers over %v\n", run.id, len(b.hosts), len(b.servers), b.protocol)
+
+		b.progress = run
+		results := runQueries(b)
+		report, _ := buildReport(results)
+
+		run.mu.Lock()
+		run.results = results
+		run.report = report
+		run.state = runDone
+		run.finished = time.Now()
+		run.mu.Unlock()
+		fmt.Printf("run %d: done, %d results\n", run.id, len(results))
+		a.forget()
+	}
+}
+
+// forget drops the oldest finished runs beyond keepRuns.
+func (a *api) forget() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	finished := 0
+	for _, run := range a.runs {
+		if run.status().Status == runDone {
+			finished++
+		}
+	}
+	var kept []*apiRun
+	for _, run := range a.runs {
+		if finished > keepRuns && run.status().Status == runDone {
+			finished--
+			continue
+		}
+		kept = append(kept, run)
+	}
+	a.runs = kept
+}
+
+// find returns the run with id, if it is still kept.
+func (a *api) find(id int) *apiRun {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, run := range a.runs {
+		if run.id == id {
+			return run
+		}
+	}
+	return nil
+}
+
+func (a *api) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.mu.Lock()
+		runs := append([]*apiRun(nil), a.runs...)
+		a.mu.Unlock()
+		statuses := []JSONStatus{}
+		for _, run := range runs {
+			statuses = append(statuses, run.status())
+		}
+		writeJSON(w, http.StatusOK, statuses)
+	case http.MethodPost:
+		var req runRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		b, err := req.benchmark()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		a.mu.Lock()
+		run := &apiRun{id: a.last + 1, bench: b, state: runQueued, created: time.Now()}
+		select {
+		case a.queue <- run:
+			a.last = run.id
+			a.runs = append(a.runs, run)
+		default:
+			a.mu.Unlock()
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("too many runs queued"))
+			return
+		}
+		a.mu.Unlock()
+		w.Header().Set("Location", fmt.Sprintf("/runs/%d", run.id))
+		writeJSON(w, http.StatusAccepted, run.status())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+	}
+}
+
+func (a *api) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs/"), "/"), "/")
+	var run *apiRun
+	if id, err := strconv.Atoi(parts[0]); err == nil {
+		run = a.find(id)
+	}
+	if run == nil || len(parts) > 2 || len(parts) == 2 && parts[1] != "results" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such run"))
+		return
+	}
+
+	status := run.status()
+	if len(parts) == 1 {
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	if status.Status != runDone {
+		writeJSON(w, http.StatusConflict, status)
+		return
+	}
+	run.mu.Lock()
+	results := append([]Result(nil), run.results...)
+	report := run.report
+	run.mu.Unlock()
+	sort.SliceStable(results, func(i, j int) bool { return results[i].at.Before(results[j].at) })
+
+	out := JSONResults{JSONStatus: status, Report: report.JSON(), Results: []JSONResult{}}
+	for _, r := range results {
+		out.Results = append(out.Results, r.JSON())
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
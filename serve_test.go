@@ -0,0 +1,129 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunRequestLimits(t *testing.T) {
+	many := func(n int, f string) []string {
+		var list []string
+		for i := 0; i < n; i++ {
+			list = append(list, fmt.Sprintf(f, i%250+1))
+		}
+		return list
+	}
+	tests := []struct {
+		req runRequest
+		ok  bool
+	}{
+		{runRequest{Servers: []string{"192.0.2.1"}, Count: 10}, true},
+		{runRequest{Servers: []string{"192.0.2.1"}, Count: maxHosts}, true},
+		{runRequest{Servers: []string{"192.0.2.1"}, Count: maxHosts + 1}, false},
+		{runRequest{Servers: []string{"192.0.2.1"}, Count: -1}, false},
+		{runRequest{Servers: []string{"192.0.2.1"}, Hosts: many(maxHosts+1, "%d.example.com")}, false},
+		{runRequest{Servers: many(maxServers, "192.0.2.%d")}, true},
+		{runRequest{Servers: many(maxServers+1, "192.0.2.%d")}, false},
+		{runRequest{Servers: []string{"dns.example"}}, false},
+		{runRequest{Servers: []string{"192.0.2.1"}, Protocol: "quic"}, false},
+	}
+	for i, tt := range tests {
+		_, err := tt.req.benchmark()
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("#%d: got error %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
+
+func TestForgetOldRuns(t *testing.T) {
+	a := &api{}
+	for id := 1; id <= keepRuns+10; id++ {
+		a.runs = append(a.runs, &apiRun{id: id, state: runDone})
+	}
+	running := &apiRun{id: keepRuns + 11, state: runRunning}
+	a.runs = append(a.runs, running)
+	a.forget()
+	if len(a.runs) != keepRuns+1 {
+		t.Fatalf("kept %d runs, want %d finished and the running one", len(a.runs), keepRuns)
+	}
+	if a.find(10) != nil || a.find(11) == nil || a.find(running.id) != running {
+		t.Error("forgot the wrong runs")
+	}
+}
+
+func TestServeRun(t *testing.T) {
+	quick(t)
+	setFlag(t, "attempts", "1")
+	server := startMock(t, &mockResolver{latency: time.Millisecond}, "127.0.0.1")
+	dead := startMock(t, &mockResolver{drop: 1}, "127.0.0.1")
+	a := &api{queue: make(chan *apiRun, 1)}
+	go a.worker()
+	defer close(a.queue)
+	ts := httptest.NewServer(a.handler())
+	defer ts.Close()
+
+	body := fmt.Sprintf(`{"servers": [%q, %q], "hosts": [%q, %q], "protocol": "udp"}`, server, dead, Top[0], Top[1])
+	resp, err := http.Post(ts.URL+"/runs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status %v", resp.Status)
+	}
+	loc := resp.Header.Get("Location")
+
+	var out JSONResults
+	var raw json.RawMessage
+	for deadline := time.Now().Add(10 * time.Second); out.Status != runDone; {
+		if time.Now().After(deadline) {
+			t.Fatal("run didn't finish")
+		}
+		time.Sleep(20 * time.Millisecond)
+		resp, err := http.Get(ts.URL + loc + "/results")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&raw)
+		resp.Body.Close()
+		json.Unmarshal(raw, &out)
+	}
+	if len(out.Results) != 4 || len(out.Report) != 2 {
+		t.Fatalf("got %d results and %d records, want both hosts asked of both servers", len(out.Results), len(out.Report))
+	}
+	live, gone := out.Report[0], out.Report[1]
+	if live.Server != server || live.Answered != 2 || live.Score == nil || live.Avg == nil {
+		t.Errorf("first record %+v, want the live server with both hosts answered", live)
+	}
+	if gone.Server != dead || gone.Answered != 0 || gone.Loss != 1 {
+		t.Errorf("last record %+v, want the dead server with everything lost", gone)
+	}
+	if gone.Score != nil || gone.Min != nil || gone.Avg != nil || gone.Jitter != nil || gone.First != nil || gone.Effective != nil {
+		t.Errorf("dead server has statistics %+v, want none", gone)
+	}
+	if strings.Count(string(raw), `"score"`) != 1 {
+		t.Errorf("want a score for the live server only in %s", raw)
+	}
+}
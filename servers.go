@@ -59,3 +59,43 @@ var Servers = []string{
 	"74.82.42.42",    // Hurricane Electric
 	"109.69.8.51",    // puntCAT
 }
+
+// Servers6 are the IPv6 endpoints of the providers above that have them.
+var Servers6 = []string{
+	"2001:4860:4860::8888",       // Google DNS #1
+	"2001:4860:4860::8844",       // Google DNS #2
+	"2620:119:35::35",            // OpenDNS #1
+	"2620:119:53::53",            // OpenDNS #2
+	"2610:a1:1018::1",            // DNS Advantage #1
+	"2610:a1:1019::1",            // DNS Advantage #2
+	"2620:74:1b::1:1",            // Verisign #1
+	"2620:74:1c::2:2",            // Verisign #2
+	"2001:1608:10:25::1c04:b12f", // DNS Watch #1
+	"2001:1608:10:25::9249:d69b", // DNS Watch #1
+	"2a01:3a0:53:53::",           // Censur Fri DNS
+	"2001:67c:28a4::",            // Censur Fri DNS
+	"2a02:6b8::feed:0ff",         // Yandex.DNS
+	"2a02:6b8:0:1::feed:0ff",     // Yandex.DNS
+	"2001:470:20::2",             // Hurricane Electric
+	"2a00:1508:0:4::9",           // puntCAT
+}
+
+// DualStack pairs each IPv6 endpoint with the provider's IPv4 server.
+var DualStack = map[string]string{
+	"2001:4860:4860::8888":       "8.8.8.8",
+	"2001:4860:4860::8844":       "8.8.4.4",
+	"2620:119:35::35":            "208.67.222.222",
+	"2620:119:53::53":            "208.67.220.220",
+	"2610:a1:1018::1":            "156.154.70.1",
+	"2610:a1:1019::1":            "156.154.71.1",
+	"2620:74:1b::1:1":            "64.6.64.6",
+	"2620:74:1c::2:2":            "64.6.65.6",
+	"2001:1608:10:25::1c04:b12f": "84.200.69.80",
+	"2001:1608:10:25::9249:d69b": "84.200.70.40",
+	"2a01:3a0:53:53::":           "89.233.43.71",
+	"2001:67c:28a4::":            "91.239.100.100",
+	"2a02:6b8::feed:0ff":         "77.88.8.8",
+	"2a02:6b8:0:1::feed:0ff":     "77.88.8.1",
+	"2001:470:20::2":             "74.82.42.42",
+	"2a00:1508:0:4::9":           "109.69.8.51",
+}
@@ -0,0 +1,186 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	sourceIPs  = flag.String("source-ip", "", "Comma separated source addresses to send queries from, every server is benchmarked through each")
+	interfaces = flag.String("interface", "", "Comma separated interfaces to send queries from, every server is benchmarked through each")
+)
+
+// sources returns the source addresses and interfaces to query through,
+// or a single empty source for the default route.
+func sources() []string {
+	var list []string
+	for _, s := range strings.Split(*sourceIPs+","+*interfaces, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	if len(list) == 0 {
+		return []string{""}
+	}
+	return list
+}
+
+// checkSources makes sure every source is a local address or interface.
+func checkSources() error {
+	for _, s := range sources() {
+		if s == "" || net.ParseIP(s) != nil {
+			continue
+		}
+		if _, err := net.InterfaceByName(s); err != nil {
+			return fmt.Errorf("source %q: %v", s, err)
+		}
+	}
+	return nil
+}
+
+// sourceAddr returns the address to send queries to server from. A source
+// naming an interface gives its first address of the server's family. It
+// returns nil if the source has no usable address.
+func sourceAddr(source, server string) net.IP {
+	if ip := net.ParseIP(source); ip != nil {
+		if sameFamily(ip, server) {
+			return ip
+		}
+		return nil
+	}
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if sameFamily(ipnet.IP, server) {
+			return ipnet.IP
+		}
+	}
+	return nil
+}
+
+// sameFamily reports whether ip can reach server. Servers that aren't
+// addresses, like the OS stub, are reachable from IPv4 sources.
+func sameFamily(ip net.IP, server string) bool {
+	host := server
+	if h, _, err := net.SplitHostPort(server); err == nil {
+		host = h
+	}
+	s := net.ParseIP(host)
+	if s == nil {
+		return ip.To4() != nil
+	}
+	return (ip.To4() != nil) == (s.To4() != nil)
+}
+
+// localAddr returns ip as the local address for a dialer on network.
+func localAddr(network string, ip net.IP) net.Addr {
+	if strings.HasPrefix(network, "tcp") {
+		return &net.TCPAddr{IP: ip}
+	}
+	return &net.UDPAddr{IP: ip}
+}
+
+// probeSource is the source the checks and baselines, which run once per
+// server rather than through every source, are sent from.
+func probeSource() string {
+	return sources()[0]
+}
+
+// dialer returns a dialer on network sending to server from source. A
+// source naming an interface is also bound to it, where the OS allows,
+// so traffic leaves through it whatever the routing table says.
+func dialer(network, source, server string, timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout}
+	if source == "" {
+		return d
+	}
+	if ip := sourceAddr(source, server); ip != nil {
+		d.LocalAddr = localAddr(network, ip)
+	}
+	if net.ParseIP(source) == nil {
+		d.Control = bindToDevice(source)
+	}
+	return d
+}
+
+// boundResolver returns a pure Go resolver sending its queries from
+// source. The cgo resolver can't be bound to a source.
+func boundResolver(source string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer(network, source, address, 0).DialContext(ctx, network, address)
+		},
+	}
+}
+
+// printSources shows every server's response times through each source
+// side by side.
+func printSources(report Report) {
+	list := sources()
+	if len(list) < 2 {
+		return
+	}
+
+	avg := make(map[string]map[string]record)
+	var servers []string
+	for _, v := range report {
+		if avg[v.server] == nil {
+			avg[v.server] = make(map[string]record)
+			servers = append(servers, v.server)
+		}
+		avg[v.server][v.source] = v
+	}
+	sort.Strings(servers)
+
+	fmt.Println("\nAverage response time by source")
+	fmt.Printf("%26v", "")
+	for _, s := range list {
+		fmt.Printf(" %24v", s)
+	}
+	fmt.Println()
+	for _, server := range servers {
+		fmt.Printf("%26v", server)
+		for _, s := range list {
+			v, ok := avg[server][s]
+			if !ok {
+				fmt.Printf(" %24v", "-")
+				continue
+			}
+			fmt.Printf(" %10.1fms fail[%4.0f]%%", v.times.avg, v.failRate())
+		}
+		fmt.Println()
+	}
+}
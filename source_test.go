@@ -0,0 +1,88 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestBoundStubLabel(t *testing.T) {
+	setFlag(t, "source-ip", "127.0.0.1")
+	setFlag(t, "resolver", "both")
+	quries := buildLocalQuries([]string{"a", "b"}, nil)
+	if len(quries) != 2 {
+		t.Fatalf("got %d stub queries, want one per host once both stubs are bound", len(quries))
+	}
+	for _, q := range quries {
+		if q.server != localServer+"-go" || !q.resolver.PreferGo {
+			t.Errorf("bound stub labelled %q, want %s-go", q.server, localServer)
+		}
+	}
+}
+
+func TestDialerSource(t *testing.T) {
+	d := dialer("udp", "127.0.0.1", "192.0.2.1:53", time.Second)
+	if a, ok := d.LocalAddr.(*net.UDPAddr); !ok || !a.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("local address %v, want 127.0.0.1", d.LocalAddr)
+	}
+	if d.Control != nil {
+		t.Error("address source bound to a device")
+	}
+	if d := dialer("tcp", "::1", "192.0.2.1:53", time.Second); d.LocalAddr != nil {
+		t.Errorf("IPv6 source used for an IPv4 server: %v", d.LocalAddr)
+	}
+}
+
+func TestInterfaceSource(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to a device needs Linux")
+	}
+	lo := ""
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			lo = iface.Name
+		}
+	}
+	if lo == "" {
+		t.Skip("no loopback interface")
+	}
+	quick(t)
+	setFlag(t, "interface", lo)
+	if d := dialer("udp", lo, "127.0.0.1:53", time.Second); d.Control == nil {
+		t.Error("interface source not bound to its device")
+	}
+
+	server := startMock(t, &mockResolver{}, "127.0.0.1")
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(Top[0]), dns.TypeA)
+	if _, _, err := exchange(server, m); err != nil {
+		t.Errorf("probe through %s: %v", lo, err)
+	}
+	results := runQueries(benchmark{hosts: mockHosts(3), servers: []string{server}, protocol: "udp", progress: discard{}})
+	for _, r := range results {
+		if !r.ok || r.source != lo {
+			t.Errorf("%v through %q, want answered through %s", r, r.source, lo)
+		}
+	}
+}
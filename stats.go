@@ -0,0 +1,162 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// millis converts times to fractional milliseconds for the statistical tests.
+func (t Times) millis() []float64 {
+	ms := make([]float64, len(t))
+	for i := range t {
+		ms[i] = float64(t[i].Nanoseconds()) / 1e6
+	}
+	return ms
+}
+
+// mean returns the average of times in fractional milliseconds.
+func (t Times) mean() float64 {
+	if len(t) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range t.millis() {
+		sum += v
+	}
+	return sum / float64(len(t))
+}
+
+// percentile returns the p-th percentile, 0 to 1, of sorted samples,
+// interpolating between the nearest two.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := p * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (pos-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// mannWhitney returns the two sided p-value of the Mann-Whitney U test for
+// samples a and b, using the normal approximation with a tie correction.
+// Samples too small to test return 1, ie. no evidence of a difference.
+func mannWhitney(a, b []float64) float64 {
+	n1, n2 := len(a), len(b)
+	if n1 < 2 || n2 < 2 {
+		return 1
+	}
+
+	type sample struct {
+		v     float64
+		first bool
+	}
+	all := make([]sample, 0, n1+n2)
+	for _, v := range a {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// rank the pooled samples, ties share the average of their ranks
+	var rankSum, ties float64
+	n := len(all)
+	for i := 0; i < n; {
+		j := i
+		for j < n && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	fn1, fn2 := float64(n1), float64(n2)
+	u := rankSum - fn1*(fn1+1)/2
+	mean := fn1 * fn2 / 2
+	variance := fn1 * fn2 / 12 * ((fn1 + fn2 + 1) - ties/((fn1+fn2)*(fn1+fn2-1)))
+	if variance <= 0 {
+		return 1
+	}
+	// continuity correction
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// wilson returns the 95% Wilson score interval for k successes in n
+// trials, which stays sensible for rates near 0.
+func wilson(k, n int) (lo, hi float64) {
+	if n == 0 {
+		return 0, 1
+	}
+	const z = 1.96
+	fn := float64(n)
+	p := float64(k) / fn
+	centre := (p + z*z/(2*fn)) / (1 + z*z/fn)
+	hw := z * math.Sqrt(p*(1-p)/fn+z*z/(4*fn*fn)) / (1 + z*z/fn)
+	return math.Max(0, centre-hw), math.Min(1, centre+hw)
+}
+
+// twoProportions returns the two sided p-value of the z-test for the
+// difference between the rates k1/n1 and k2/n2.
+func twoProportions(k1, n1, k2, n2 int) float64 {
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	p := float64(k1+k2) / float64(n1+n2)
+	se := math.Sqrt(p * (1 - p) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 1
+	}
+	z := math.Abs(float64(k1)/float64(n1)-float64(k2)/float64(n2)) / se
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// assignTiers groups an ordered report into tiers. A new tier is started
+// only when a server is significantly slower, or loses significantly more
+// queries, than the one ranked above it, or answered nothing at all, so
+// servers that can't be told apart share a tier.
+func assignTiers(report Report, alpha float64) {
+	tier := 1
+	for i := range report {
+		if i > 0 {
+			a, b := report[i-1], report[i]
+			p := mannWhitney(a.samples.millis(), b.samples.millis())
+			q := twoProportions(a.loss.lost, a.loss.sent, b.loss.lost, b.loss.sent)
+			if p < alpha || q < alpha || len(a.samples) > 0 && len(b.samples) == 0 {
+				tier++
+			}
+		}
+		report[i].tier = tier
+	}
+}
@@ -0,0 +1,85 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestMannWhitney(t *testing.T) {
+	tests := []struct {
+		a, b []float64
+		p    float64
+	}{
+		// completely separated, U = 0: z = (12.5-0.5)/sqrt(25*11/12)
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 0.01219},
+		{[]float64{6, 7, 8, 9, 10}, []float64{1, 2, 3, 4, 5}, 0.01219},
+		// interleaved, U = 10: z = (2.5-0.5)/sqrt(25*11/12)
+		{[]float64{1, 3, 5, 7, 9}, []float64{2, 4, 6, 8, 10}, 0.6761},
+		// all ties leave no variance to test
+		{[]float64{5, 5, 5}, []float64{5, 5, 5}, 1},
+		// too few samples
+		{[]float64{1}, []float64{2, 3, 4}, 1},
+	}
+	for _, tt := range tests {
+		if p := mannWhitney(tt.a, tt.b); !near(p, tt.p, 1e-3) {
+			t.Errorf("mannWhitney(%v, %v) = %.5f, want %.5f", tt.a, tt.b, p, tt.p)
+		}
+	}
+}
+
+func TestWilson(t *testing.T) {
+	tests := []struct {
+		k, n   int
+		lo, hi float64
+	}{
+		{0, 10, 0, 0.2775},
+		{5, 10, 0.2366, 0.7634},
+		{10, 10, 0.7225, 1},
+		{0, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		lo, hi := wilson(tt.k, tt.n)
+		if !near(lo, tt.lo, 1e-4) || !near(hi, tt.hi, 1e-4) {
+			t.Errorf("wilson(%d, %d) = %.4f-%.4f, want %.4f-%.4f", tt.k, tt.n, lo, hi, tt.lo, tt.hi)
+		}
+	}
+}
+
+func TestTwoProportions(t *testing.T) {
+	tests := []struct {
+		k1, n1, k2, n2 int
+		p              float64
+	}{
+		{10, 100, 10, 100, 1},
+		// pooled p = 0.05, z = 0.1/sqrt(0.05*0.95*0.02)
+		{0, 100, 10, 100, 0.00118},
+		{0, 100, 0, 100, 1},
+		{1, 0, 1, 10, 1},
+	}
+	for _, tt := range tests {
+		if p := twoProportions(tt.k1, tt.n1, tt.k2, tt.n2); !near(p, tt.p, 1e-4) {
+			t.Errorf("twoProportions(%d, %d, %d, %d) = %.5f, want %.5f", tt.k1, tt.n1, tt.k2, tt.n2, p, tt.p)
+		}
+	}
+}
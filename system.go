@@ -0,0 +1,139 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	resolvConf = flag.String("resolvconf", "/etc/resolv.conf", "resolv.conf listing the system nameservers")
+	systemDNS  = flag.Bool("system", true, "Also benchmark the system nameservers directly")
+	resolver   = flag.String("resolver", "default", "OS resolver behind the stub lookups: default, go, cgo, both or none")
+)
+
+// systemd-resolved lists its upstream servers here when resolv.conf only
+// points at its local stub listener.
+const resolvedConf = "/run/systemd/resolve/resolv.conf"
+
+// systemServers returns the nameservers the OS is configured to use, so
+// they can be queried with dnsworker like any cloud server. If one of them
+// is the systemd-resolved stub its upstreams are included too.
+func systemServers() []string {
+	if !*systemDNS {
+		return nil
+	}
+	servers := nameservers(*resolvConf)
+	for _, s := range servers {
+		if ip := net.ParseIP(s); ip != nil && ip.IsLoopback() {
+			servers = append(servers, nameservers(resolvedConf)...)
+			break
+		}
+	}
+	return servers
+}
+
+func nameservers(file string) []string {
+	config, err := dns.ClientConfigFromFile(file)
+	if err != nil {
+		return nil
+	}
+	return config.Servers
+}
+
+// benchServers returns the servers of the -family selected to benchmark,
+// the cloud servers followed by any system nameservers not already among
+// them.
+func benchServers() []string {
+	var servers []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{Servers, Servers6, systemServers()} {
+		for _, s := range list {
+			if !seen[s] && inFamily(s) {
+				seen[s] = true
+				servers = append(servers, s)
+			}
+		}
+	}
+	return servers
+}
+
+// stub is an OS resolver path benchmarked through localLookup.
+type stub struct {
+	name     string
+	resolver *net.Resolver
+}
+
+// setupResolver forces the cgo resolver for the default net.Resolver when
+// it was asked for, keeping any other GODEBUG settings. It must run before
+// the first lookup, as the net package reads GODEBUG only once. Binaries
+// built without cgo can't use it, so asking for it there is an error
+// rather than a pure Go row labelled cgo.
+func setupResolver() error {
+	switch *resolver {
+	case "default", "go", "none":
+		return nil
+	case "cgo", "both":
+	default:
+		return fmt.Errorf("resolver %q: want default, go, cgo, both or none", *resolver)
+	}
+	if !cgoEnabled {
+		return fmt.Errorf("resolver %q: this binary was built without cgo", *resolver)
+	}
+	return os.Setenv("GODEBUG", setGODEBUG(os.Getenv("GODEBUG"), "netdns", "cgo"))
+}
+
+// setGODEBUG returns the GODEBUG settings in godebug with key set to value.
+func setGODEBUG(godebug, key, value string) string {
+	var settings []string
+	for _, kv := range strings.Split(godebug, ",") {
+		if kv != "" && !strings.HasPrefix(kv, key+"=") {
+			settings = append(settings, kv)
+		}
+	}
+	return strings.Join(append(settings, key+"="+value), ",")
+}
+
+// stubs returns the OS resolver paths selected by -resolver.
+func stubs() []stub {
+	goResolver := stub{localServer + "-go", &net.Resolver{PreferGo: true}}
+	cgoResolver := stub{localServer + "-cgo", net.DefaultResolver}
+	switch *resolver {
+	case "go":
+		return []stub{goResolver}
+	case "cgo":
+		return []stub{cgoResolver}
+	case "both":
+		return []stub{goResolver, cgoResolver}
+	case "none":
+		return nil
+	}
+	return []stub{{localServer, net.DefaultResolver}}
+}
+
+// isStub reports whether server names one of the OS resolver paths,
+// including the pure Go one a bound source swaps in.
+func isStub(server string) bool {
+	return server == localServer || strings.HasPrefix(server, localServer+"-")
+}
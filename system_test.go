@@ -0,0 +1,71 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSetGODEBUG(t *testing.T) {
+	tests := []struct {
+		godebug, want string
+	}{
+		{"", "netdns=cgo"},
+		{"http2client=0", "http2client=0,netdns=cgo"},
+		{"netdns=go,madvdontneed=1", "madvdontneed=1,netdns=cgo"},
+		{"netdns=go+1", "netdns=cgo"},
+		{"a=1,,b=2", "a=1,b=2,netdns=cgo"},
+	}
+	for _, tt := range tests {
+		if got := setGODEBUG(tt.godebug, "netdns", "cgo"); got != tt.want {
+			t.Errorf("setGODEBUG(%q) = %q, want %q", tt.godebug, got, tt.want)
+		}
+	}
+}
+
+func TestSetupResolver(t *testing.T) {
+	old, had := os.LookupEnv("GODEBUG")
+	defer func() {
+		if had {
+			os.Setenv("GODEBUG", old)
+		} else {
+			os.Unsetenv("GODEBUG")
+		}
+	}()
+	os.Setenv("GODEBUG", "http2client=0")
+
+	setFlag(t, "resolver", "go")
+	if err := setupResolver(); err != nil || os.Getenv("GODEBUG") != "http2client=0" {
+		t.Errorf("-resolver go: %v, GODEBUG %q", err, os.Getenv("GODEBUG"))
+	}
+	setFlag(t, "resolver", "stub")
+	if setupResolver() == nil {
+		t.Error("unknown -resolver accepted")
+	}
+	for _, r := range []string{"cgo", "both"} {
+		setFlag(t, "resolver", r)
+		err := setupResolver()
+		if cgoEnabled && (err != nil || os.Getenv("GODEBUG") != "http2client=0,netdns=cgo") {
+			t.Errorf("-resolver %s: %v, GODEBUG %q", r, err, os.Getenv("GODEBUG"))
+		}
+		if !cgoEnabled && err == nil {
+			t.Errorf("-resolver %s accepted without cgo", r)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var ui = flag.String("ui", "auto", "Progress display: tui for a live table, plain for a line per query, auto for tui on a terminal")
+
+// observer is told about the queries of each run and their results as
+// they come in.
+type observer interface {
+	start(queries []Query)
+	result(r Result)
+	done()
+}
+
+// progress shows the benchmark's progress, set up by setupUI.
+var progress observer = plain{}
+
+// setupUI picks the progress display for -ui.
+func setupUI() error {
+	switch *ui {
+	case "plain":
+	case "tui":
+		progress = newTUI()
+	case "auto":
+		if isTerminal(os.Stdout) {
+			progress = newTUI()
+		}
+	default:
+		return fmt.Errorf("ui %q: want auto, tui or plain", *ui)
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// plain prints a line per result, for logs and pipes.
+type plain struct{}
+
+func (plain) start([]Query)   {}
+func (plain) result(r Result) { fmt.Println(r) }
+func (plain) done()           {}
+
+// sparkWidth is the number of recent response times in each sparkline.
+const sparkWidth = 24
+
+// serverProgress is one server's row in the live table.
+type serverProgress struct {
+	name    string
+	planned int
+	done    int
+	errors  int
+	sorted  Times // every response time, kept in order for the median
+	recent  Times // the last sparkWidth response times, for the sparkline
+}
+
+// add records a response time, inserting it in order so the median needs
+// no sort.
+func (s *serverProgress) add(rtt time.Duration) {
+	i := sort.Search(len(s.sorted), func(i int) bool { return s.sorted[i] > rtt })
+	s.sorted = append(s.sorted, 0)
+	copy(s.sorted[i+1:], s.sorted[i:])
+	s.sorted[i] = rtt
+	s.recent = append(s.recent, rtt)
+	if len(s.recent) > sparkWidth {
+		s.recent = s.recent[1:]
+	}
+}
+
+// median returns the median response time in milliseconds, or -1 before
+// the first answer.
+func (s *serverProgress) median() float64 {
+	n := len(s.sorted)
+	if n == 0 {
+		return -1
+	}
+	m := s.sorted[n/2]
+	if n%2 == 0 {
+		m = (s.sorted[n/2-1] + s.sorted[n/2]) / 2
+	}
+	return ms(m)
+}
+
+// tui redraws a table of every server's progress in place, a few times a
+// second, using plain ANSI escapes. The table is clipped to the terminal
+// so moving back over it never scrolls.
+type tui struct {
+	mu      sync.Mutex
+	servers map[string]*serverProgress
+	order   []string
+	started time.Time
+	lines   int // drawn last time, to move back over
+	stop    chan bool
+	stopped chan bool
+
+	out  io.Writer
+	size func() (rows, cols int) // of the terminal
+}
+
+func newTUI() *tui {
+	return &tui{
+		servers: make(map[string]*serverProgress),
+		out:     os.Stdout,
+		size:    func() (int, int) { return terminalSize(os.Stdout) },
+	}
+}
+
+func (t *tui) start(queries []Query) {
+	t.mu.Lock()
+	if t.started.IsZero() {
+		t.started = time.Now()
+	}
+	for _, q := range queries {
+		name := label(q.server, q.source)
+		s := t.servers[name]
+		if s == nil {
+			s = &serverProgress{name: name}
+			t.servers[name] = s
+			t.order = append(t.order, name)
+		}
+		s.planned++
+	}
+	t.lines = 0
+	t.stop = make(chan bool)
+	t.stopped = make(chan bool)
+	t.mu.Unlock()
+
+	go func() {
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				t.draw()
+			case <-t.stop:
+				t.draw()
+				close(t.stopped)
+				return
+			}
+		}
+	}()
+}
+
+func (t *tui) result(r Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.servers[r.name()]
+	if s == nil {
+		return
+	}
+	s.done++
+	if r.ok {
+		s.add(r.rtt)
+	} else {
+		s.errors++
+	}
+}
+
+func (t *tui) done() {
+	close(t.stop)
+	<-t.stopped
+}
+
+// draw moves back over the last table and prints the current one, the
+// servers ranked by their median response time so far, as many as fit.
+func (t *tui) draw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rows := make([]*serverProgress, 0, len(t.order))
+	medians := make(map[string]float64)
+	planned, done := 0, 0
+	for _, name := range t.order {
+		s := t.servers[name]
+		rows = append(rows, s)
+		medians[name] = s.median()
+		planned += s.planned
+		done += s.done
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := medians[rows[i].name], medians[rows[j].name]
+		if (a < 0) != (b < 0) {
+			return b < 0
+		}
+		return a < b
+	})
+
+	var b strings.Builder
+	if t.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", t.lines)
+	}
+	height, width := t.size()
+	line := func(s string) {
+		fmt.Fprintf(&b, "\x1b[2K%s\n", clip(s, width-1))
+	}
+	line(fmt.Sprintf("%d/%d queries, %v", done, planned, time.Since(t.started).Round(time.Second)))
+	// leave a line for the header, one for the hidden count and one for
+	// the cursor
+	hidden := 0
+	if room := height - 3; len(rows) > room {
+		if room < 1 {
+			room = 1
+		}
+		hidden = len(rows) - room
+		rows = rows[:room]
+	}
+	for k, s := range rows {
+		median := "      -"
+		if medians[s.name] >= 0 {
+			median = fmt.Sprintf("%7.1f", medians[s.name])
+		}
+		row := func(barWidth int) string {
+			return fmt.Sprintf("#%2d %15v %v %5d/%-5d median[%v]ms errors[%4d]",
+				k+1, s.name, bar(s.done, s.planned, barWidth), s.done, s.planned, median, s.errors)
+		}
+		// narrow the bar, then drop the sparkline, before clipping
+		r := row(20)
+		if spark := r + " " + sparkline(s.recent, sparkWidth); utf8.RuneCountInString(spark) < width {
+			r = spark
+		} else if utf8.RuneCountInString(r) >= width {
+			r = row(10)
+		}
+		line(r)
+	}
+	t.lines = len(rows) + 1
+	if hidden > 0 {
+		line(fmt.Sprintf("    and %d slower servers", hidden))
+		t.lines++
+	}
+	io.WriteString(t.out, b.String())
+}
+
+// bar draws a progress bar width characters wide.
+func bar(done, total, width int) string {
+	filled := width
+	if total > 0 {
+		filled = done * width / total
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// clip cuts s to at most width runes.
+func clip(s string, width int) string {
+	if width < 0 {
+		width = 0
+	}
+	for i := range s {
+		if width == 0 {
+			return s[:i]
+		}
+		width--
+	}
+	return s
+}
+
+// sparkline draws the last n response times in times, scaled from the
+// fastest to the slowest of them.
+func sparkline(times Times, n int) string {
+	if len(times) > n {
+		times = times[len(times)-n:]
+	}
+	if len(times) == 0 {
+		return ""
+	}
+	levels := []rune("▁▂▃▄▅▆▇█")
+	lo, hi := times[0], times[0]
+	for _, t := range times {
+		if t < lo {
+			lo = t
+		}
+		if t > hi {
+			hi = t
+		}
+	}
+	spark := make([]rune, len(times))
+	for i, t := range times {
+		level := 0
+		if hi > lo {
+			level = int(int64(t-lo) * int64(len(levels)-1) / int64(hi-lo))
+		}
+		spark[i] = levels[level]
+	}
+	return string(spark)
+}
@@ -0,0 +1,91 @@
+// CloudDNSBenchmark
+// Copyright (C) 2016 Josh Gardiner
+
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, write to the Free Software Foundation, Inc.,
+// 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestServerProgressMedian(t *testing.T) {
+	var s serverProgress
+	if m := s.median(); m != -1 {
+		t.Errorf("median of nothing = %v, want -1", m)
+	}
+	var all Times
+	for _, v := range []int{9, 1, 5, 3, 7, 2, 8, 30, 4, 6} {
+		d := time.Duration(v) * time.Millisecond
+		s.add(d)
+		all = append(all, d)
+		if got, want := s.median(), ms(medianDuration(all)); got != want {
+			t.Errorf("after %d samples median = %v, want %v", len(all), got, want)
+		}
+	}
+	for i := 1; i < len(s.sorted); i++ {
+		if s.sorted[i] < s.sorted[i-1] {
+			t.Fatalf("samples out of order: %v", s.sorted)
+		}
+	}
+	for i := 0; i < 3*sparkWidth; i++ {
+		s.add(time.Millisecond)
+	}
+	if len(s.recent) != sparkWidth {
+		t.Errorf("kept %d recent samples, want %d", len(s.recent), sparkWidth)
+	}
+}
+
+func TestTUIClipsToTerminal(t *testing.T) {
+	var out bytes.Buffer
+	tui := newTUI()
+	tui.out = &out
+	tui.size = func() (int, int) { return 8, 60 }
+
+	var quries []Query
+	for i := 0; i < 20; i++ {
+		quries = append(quries, Query{server: fmt.Sprintf("192.0.2.%d", i+1)})
+	}
+	tui.start(quries)
+	for i, q := range quries {
+		tui.result(Result{server: q.server, ok: true, rtt: time.Duration(i+1) * time.Millisecond})
+	}
+	tui.done()
+
+	frames := strings.Split(out.String(), "\x1b[2K")
+	last := strings.Join(frames[len(frames)-7:], "")
+	if n := strings.Count(last, "\n"); n != 7 {
+		t.Errorf("last frame is %d lines, want 7 to fit 8 rows", n)
+	}
+	if !strings.Contains(last, "and 15 slower servers") {
+		t.Errorf("last frame doesn't count the hidden servers:\n%s", last)
+	}
+	if !strings.Contains(last, "192.0.2.1 ") || strings.Contains(last, "192.0.2.20") {
+		t.Errorf("last frame doesn't show the fastest servers:\n%s", last)
+	}
+	for _, l := range strings.Split(out.String(), "\n") {
+		if n := utf8.RuneCountInString(strings.ReplaceAll(l, "\x1b[2K", "")); n >= 60 {
+			t.Errorf("line is %d columns, want it to fit 60: %q", n, l)
+		}
+	}
+	if tui.lines != 7 {
+		t.Errorf("moves back %d lines, want 7", tui.lines)
+	}
+}